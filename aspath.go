@@ -0,0 +1,183 @@
+package tracelib
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ASSegment is part of trace inside one AS
+type ASSegment struct {
+	AS int64
+	// FirstHop and LastHop are 1-based numbers of entry and exit hops
+	FirstHop int
+	LastHop  int
+	Entry    net.Addr
+	Exit     net.Addr
+	EntryRTT time.Duration
+	ExitRTT  time.Duration
+	// RTTContribution is RTT added by this AS (and link into it), computed
+	// as difference of exit RTTs of this and previous segment
+	RTTContribution time.Duration
+	// GapBefore is number of unknown/private hops between previous segment
+	// and this one
+	GapBefore int
+	// ViaIXP is exchange path entered this AS through, if known
+	ViaIXP *IXPInfo
+}
+
+// ASPath is AS-level summary of trace
+type ASPath struct {
+	Segments []ASSegment
+	// Loop is true if some AS appears again after path left it
+	Loop     bool
+	LoopASes []int64
+}
+
+// ASNumbers returns sequence of AS numbers of path
+func (p ASPath) ASNumbers() []int64 {
+	result := make([]int64, 0, len(p.Segments))
+	for _, s := range p.Segments {
+		result = append(result, s.AS)
+	}
+	return result
+}
+
+// String returns summary like "AS3356 -> via DE-CIX Frankfurt -> AS8560"
+func (p ASPath) String() string {
+	parts := make([]string, 0, 2*len(p.Segments))
+	for _, s := range p.Segments {
+		if nil != s.ViaIXP {
+			parts = append(parts, "via "+s.ViaIXP.Name)
+		}
+		parts = append(parts, "AS"+strconv.FormatInt(s.AS, 10))
+	}
+	return strings.Join(parts, " -> ")
+}
+
+// pathHop is single representative responder of hop
+type pathHop struct {
+	hop  int
+	addr net.Addr
+	as   int64
+	rtt  time.Duration
+	ixp  *IXPInfo
+}
+
+// newPathHop creates pathHop, peering LAN addresses belong to member AS
+func newPathHop(hop int, addr net.Addr, as int64, rtt time.Duration, ixp *IXPInfo) pathHop {
+	if nil != ixp && ixp.MemberASN > 0 {
+		as = ixp.MemberASN
+	}
+	return pathHop{hop: hop, addr: addr, as: as, rtt: rtt, ixp: ixp}
+}
+
+// ASPathFromHops creates AS path from result of RunTrace
+func ASPathFromHops(hops []Hop) ASPath {
+	phops := make([]pathHop, 0, len(hops))
+	for i, h := range hops {
+		if nil == h.Addr {
+			continue
+		}
+		var rtt time.Duration
+		if !h.Timeout && nil == h.Error {
+			rtt = h.RTT
+		}
+		phops = append(phops, newPathHop(i+1, h.Addr, h.AS, rtt, h.IXP))
+	}
+
+	return buildASPath(phops)
+}
+
+// ASPathFromMulti creates AS path from aggregated trace, for every hop
+// responder with most replies is used
+func ASPathFromMulti(hops [][]MHop) ASPath {
+	phops := make([]pathHop, 0, len(hops))
+	for i, hop := range hops {
+		if best := bestResponder(hop); nil != best {
+			phops = append(phops, newPathHop(i+1, best.Addr, best.AS, best.MinRTT, best.IXP))
+		}
+	}
+
+	return buildASPath(phops)
+}
+
+// buildASPath collapses consecutive hops of same AS into segments; unknown
+// hops inside one AS are absorbed, between different ASes counted as gap
+func buildASPath(hops []pathHop) ASPath {
+	var (
+		path    ASPath
+		gap     int
+		via     *IXPInfo
+		prevRTT time.Duration
+		seen    = map[int64]bool{}
+	)
+
+	for _, h := range hops {
+		if nil != h.ixp {
+			via = h.ixp
+		}
+
+		if h.as <= 0 {
+			gap++
+			continue
+		}
+
+		if n := len(path.Segments); n > 0 && path.Segments[n-1].AS == h.as {
+			s := &path.Segments[n-1]
+			s.LastHop = h.hop
+			s.Exit = h.addr
+			if h.rtt > 0 {
+				s.ExitRTT = h.rtt
+			}
+			gap = 0
+			via = nil
+			continue
+		}
+
+		if n := len(path.Segments); n > 0 {
+			prev := &path.Segments[n-1]
+			prev.RTTContribution = rttContribution(prev.ExitRTT, prevRTT)
+			if prev.ExitRTT > prevRTT {
+				prevRTT = prev.ExitRTT
+			}
+		}
+
+		if seen[h.as] {
+			path.Loop = true
+			path.LoopASes = append(path.LoopASes, h.as)
+		}
+		seen[h.as] = true
+
+		path.Segments = append(path.Segments, ASSegment{
+			AS:        h.as,
+			FirstHop:  h.hop,
+			LastHop:   h.hop,
+			Entry:     h.addr,
+			Exit:      h.addr,
+			EntryRTT:  h.rtt,
+			ExitRTT:   h.rtt,
+			GapBefore: gap,
+			ViaIXP:    via,
+		})
+		gap = 0
+		via = nil
+	}
+
+	if n := len(path.Segments); n > 0 {
+		last := &path.Segments[n-1]
+		last.RTTContribution = rttContribution(last.ExitRTT, prevRTT)
+	}
+
+	return path
+}
+
+// rttContribution returns non-negative RTT difference, RTTs of hops aren't
+// monotonic because of ICMP generation delays
+func rttContribution(exit, prev time.Duration) time.Duration {
+	if 0 == exit || exit < prev {
+		return 0
+	}
+	return exit - prev
+}
@@ -0,0 +1,166 @@
+package tracelib
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// cacheFile is on-disk representation of LookupCache
+type cacheFile struct {
+	AS    map[string]asEntry   `json:"as"`
+	Hosts map[string]hostEntry `json:"hosts"`
+}
+
+// Save writes all non-expired cache entries to w as JSON
+func (cache *LookupCache) Save(w io.Writer) error {
+	data := cacheFile{
+		AS:    make(map[string]asEntry),
+		Hosts: make(map[string]hostEntry),
+	}
+
+	cache.aMutex.RLock()
+	for k, v := range cache.as {
+		if !cache.expired(v.Time) {
+			data.AS[k] = v
+		}
+	}
+	cache.aMutex.RUnlock()
+
+	cache.hMutex.RLock()
+	for k, v := range cache.hosts {
+		if !cache.expired(v.Time) {
+			data.Hosts[k] = v
+		}
+	}
+	cache.hMutex.RUnlock()
+
+	return json.NewEncoder(w).Encode(&data)
+}
+
+// Load reads entries previously stored by Save, expired entries are skipped
+// and entries already present in cache are replaced only by newer ones
+func (cache *LookupCache) Load(r io.Reader) error {
+	var data cacheFile
+
+	if err := json.NewDecoder(r).Decode(&data); nil != err {
+		return err
+	}
+
+	cache.aMutex.Lock()
+	for k, v := range data.AS {
+		if cache.expired(v.Time) {
+			continue
+		}
+		if old, ok := cache.as[k]; ok && old.Time.After(v.Time) {
+			continue
+		}
+		cache.as[k] = v
+	}
+	cache.aMutex.Unlock()
+
+	cache.hMutex.Lock()
+	for k, v := range data.Hosts {
+		if cache.expired(v.Time) {
+			continue
+		}
+		if old, ok := cache.hosts[k]; ok && old.Time.After(v.Time) {
+			continue
+		}
+		cache.hosts[k] = v
+	}
+	cache.hMutex.Unlock()
+
+	return nil
+}
+
+// SaveFile stores cache into filename, file is replaced atomically
+func (cache *LookupCache) SaveFile(filename string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if nil != err {
+		return err
+	}
+
+	err = cache.Save(tmp)
+	if cerr := tmp.Close(); nil == err {
+		err = cerr
+	}
+	if nil != err {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), filename); nil != err {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return nil
+}
+
+// LoadFile loads cache stored by SaveFile
+func (cache *LookupCache) LoadFile(filename string) error {
+	f, err := os.Open(filename)
+	if nil != err {
+		return err
+	}
+	defer f.Close()
+
+	return cache.Load(f)
+}
+
+// NewLookupCacheFromFile creates cache with specified TTL and warms it up
+// from filename, missing file isn't an error (first start)
+func NewLookupCacheFromFile(filename string, ttl time.Duration) (*LookupCache, error) {
+	cache := NewLookupCache()
+	cache.TTL = ttl
+
+	err := cache.LoadFile(filename)
+	if nil != err && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return cache, nil
+}
+
+// AutoSave periodically stores cache into filename until returned stop
+// function is called; stop saves cache one last time and returns error of
+// this final save. Errors of periodic saves are passed to onError if not nil
+func (cache *LookupCache) AutoSave(filename string, interval time.Duration, onError func(error)) (stop func() error, err error) {
+	if interval <= 0 {
+		return nil, errors.New("Auto save interval must be positive")
+	}
+
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	var once sync.Once
+
+	go func() {
+		defer close(finished)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := cache.SaveFile(filename); nil != err && nil != onError {
+					onError(err)
+				}
+			}
+		}
+	}()
+
+	return func() error {
+		once.Do(func() { close(done) })
+		<-finished
+		return cache.SaveFile(filename)
+	}, nil
+}
@@ -0,0 +1,198 @@
+package tracelib
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"sync"
+)
+
+// AddrClass is classification of hop address
+type AddrClass int
+
+const (
+	// ClassUnknown is used for hops without address
+	ClassUnknown AddrClass = iota
+	// ClassPublic is globally routable address
+	ClassPublic
+	// ClassPrivate is RFC1918 or IPv6 ULA address
+	ClassPrivate
+	// ClassSharedCGNAT is RFC6598 shared address space 100.64.0.0/10
+	ClassSharedCGNAT
+	// ClassLoopback is loopback address
+	ClassLoopback
+	// ClassLinkLocal is link-local address
+	ClassLinkLocal
+	// ClassDocumentation is address reserved for documentation
+	ClassDocumentation
+	// ClassBogon is any other reserved, unallocated or multicast address
+	ClassBogon
+	// ClassIXP is address from IXP peering LAN
+	ClassIXP
+)
+
+var addrClassNames = map[AddrClass]string{
+	ClassUnknown:       "unknown",
+	ClassPublic:        "public",
+	ClassPrivate:       "private",
+	ClassSharedCGNAT:   "cgnat",
+	ClassLoopback:      "loopback",
+	ClassLinkLocal:     "link-local",
+	ClassDocumentation: "documentation",
+	ClassBogon:         "bogon",
+	ClassIXP:           "ixp",
+}
+
+func (c AddrClass) String() string {
+	if name, ok := addrClassNames[c]; ok {
+		return name
+	}
+	return "invalid"
+}
+
+// Routable returns true for addresses worth looking up in AS and rDNS
+// services (public and IXP addresses)
+func (c AddrClass) Routable() bool {
+	return ClassPublic == c || ClassIXP == c
+}
+
+// special purpose ranges, first match wins
+var specialPrefixes = []struct {
+	prefix string
+	class  AddrClass
+}{
+	{"0.0.0.0/8", ClassBogon},
+	{"10.0.0.0/8", ClassPrivate},
+	{"100.64.0.0/10", ClassSharedCGNAT},
+	{"127.0.0.0/8", ClassLoopback},
+	{"169.254.0.0/16", ClassLinkLocal},
+	{"172.16.0.0/12", ClassPrivate},
+	{"192.0.0.0/24", ClassBogon},
+	{"192.0.2.0/24", ClassDocumentation},
+	{"192.168.0.0/16", ClassPrivate},
+	{"198.18.0.0/15", ClassBogon},
+	{"198.51.100.0/24", ClassDocumentation},
+	{"203.0.113.0/24", ClassDocumentation},
+	{"224.0.0.0/4", ClassBogon},
+	{"240.0.0.0/4", ClassBogon},
+	{"::/128", ClassBogon},
+	{"::1/128", ClassLoopback},
+	{"64:ff9b:1::/48", ClassPrivate},
+	{"100::/64", ClassBogon},
+	{"2001:2::/48", ClassBogon},
+	{"2001:10::/28", ClassBogon},
+	{"2001:db8::/32", ClassDocumentation},
+	{"3fff::/20", ClassDocumentation},
+	{"fc00::/7", ClassPrivate},
+	{"fe80::/10", ClassLinkLocal},
+	{"ff00::/8", ClassBogon},
+}
+
+var (
+	specialNets    []*net.IPNet
+	specialClasses []AddrClass
+	globalUnicast6 *net.IPNet
+	nat64          *net.IPNet
+)
+
+func init() {
+	for _, p := range specialPrefixes {
+		_, n, err := net.ParseCIDR(p.prefix)
+		if nil != err {
+			panic(err)
+		}
+		specialNets = append(specialNets, n)
+		specialClasses = append(specialClasses, p.class)
+	}
+	_, globalUnicast6, _ = net.ParseCIDR("2000::/3")
+	_, nat64, _ = net.ParseCIDR("64:ff9b::/96")
+}
+
+// ClassifyAddr returns class of ip using built-in special purpose ranges only
+func ClassifyAddr(ip net.IP) AddrClass {
+	if nil == ip {
+		return ClassUnknown
+	}
+	if ip4 := ip.To4(); nil != ip4 {
+		ip = ip4
+	}
+
+	// NAT64 well-known prefix (RFC 6052) is classified by embedded IPv4
+	if len(ip) == net.IPv6len && nat64.Contains(ip) {
+		return ClassifyAddr(net.IP(ip[12:16]))
+	}
+
+	for i, n := range specialNets {
+		if n.Contains(ip) {
+			return specialClasses[i]
+		}
+	}
+
+	// only 2000::/3 is allocated for global unicast
+	if len(ip) == net.IPv6len && !globalUnicast6.Contains(ip) {
+		return ClassBogon
+	}
+
+	return ClassPublic
+}
+
+// Classifier classifies addresses using built-in ranges and offline list
+// of IXP peering LAN prefixes
+type Classifier struct {
+	mutex sync.RWMutex
+	ixp   []*net.IPNet
+}
+
+// NewClassifier creates classifier without IXP prefixes
+func NewClassifier() *Classifier {
+	return &Classifier{}
+}
+
+// AddIXPPrefix adds IXP peering LAN prefix in CIDR notation
+func (c *Classifier) AddIXPPrefix(prefix string) error {
+	_, n, err := net.ParseCIDR(prefix)
+	if nil != err {
+		return err
+	}
+
+	c.mutex.Lock()
+	c.ixp = append(c.ixp, n)
+	c.mutex.Unlock()
+
+	return nil
+}
+
+// LoadIXPPrefixes reads IXP prefixes one per line, empty lines and lines
+// starting with # are ignored
+func (c *Classifier) LoadIXPPrefixes(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if "" == line || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := c.AddIXPPrefix(strings.Fields(line)[0]); nil != err {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// Classify returns class of ip, nil classifier works like ClassifyAddr
+func (c *Classifier) Classify(ip net.IP) AddrClass {
+	class := ClassifyAddr(ip)
+	if ClassPublic != class || nil == c {
+		return class
+	}
+
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	for _, n := range c.ixp {
+		if n.Contains(ip) {
+			return ClassIXP
+		}
+	}
+
+	return class
+}
@@ -0,0 +1,277 @@
+package tracelib
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultCymruWhoisServer is address of Team Cymru whois service
+const DefaultCymruWhoisServer = "whois.cymru.com:43"
+
+// ErrASNotFound returned by ASResolver when address has no origin AS
+var ErrASNotFound = errors.New("AS number not found")
+
+// ASResolver resolves origin AS number of IP address
+type ASResolver interface {
+	LookupAS(ip string) (int64, error)
+}
+
+// BulkASResolver is ASResolver able to resolve many addresses at once,
+// addresses without known AS are omitted from result
+type BulkASResolver interface {
+	ASResolver
+	LookupASBulk(ips []string) (map[string]int64, error)
+}
+
+// CymruDNS resolves AS numbers using origin.asn.cymru.com DNS TXT records
+type CymruDNS struct{}
+
+// LookupAS returns AS number for IP using origin.asn.cymru.com service
+func (CymruDNS) LookupAS(ip string) (int64, error) {
+	i := net.ParseIP(ip)
+	if nil == i {
+		return -1, errors.New("Invalid IP address " + ip)
+	}
+
+	if i4 := i.To4(); nil != i4 {
+		return lookupCymruTXT(fmt.Sprintf("%d.%d.%d.%d.origin.asn.cymru.com", i4[3], i4[2], i4[1], i4[0]))
+	}
+
+	hexIP := ""
+	for _, v := range hex.EncodeToString([]byte(i.To16())) {
+		hexIP = string(v) + "." + hexIP
+	}
+
+	return lookupCymruTXT(hexIP + "origin6.asn.cymru.com")
+}
+
+func lookupCymruTXT(name string) (int64, error) {
+	txts, err := net.LookupTXT(name)
+	if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+		// unannounced addresses have no record
+		return -1, ErrASNotFound
+	}
+	if nil != err {
+		return -1, err
+	}
+	if len(txts) < 1 {
+		return -1, ErrASNotFound
+	}
+
+	parts := strings.Split(txts[0], " | ")
+	if len(parts) < 2 {
+		return -1, ErrASNotFound
+	}
+
+	// multi-origin prefixes are returned as space separated list
+	fields := strings.Fields(parts[0])
+	if len(fields) == 0 {
+		return -1, ErrASNotFound
+	}
+
+	return strconv.ParseInt(fields[0], 10, 64)
+}
+
+// CymruWhois resolves AS numbers using Team Cymru whois bulk interface,
+// all addresses are resolved in one TCP session
+type CymruWhois struct {
+	// Server is host:port of whois service, DefaultCymruWhoisServer if empty
+	Server string
+	// Timeout limits whole bulk session, 30 seconds if 0
+	Timeout time.Duration
+	// BatchSize limits number of addresses sent in one session, 0 means no limit
+	BatchSize int
+}
+
+// LookupAS returns AS number for single IP using CymruDNS, whois sessions
+// are opened only for bulk requests, so falling back to one by one lookups
+// after failed bulk request doesn't flood whois server with connections
+func (w CymruWhois) LookupAS(ip string) (int64, error) {
+	return CymruDNS{}.LookupAS(ip)
+}
+
+// LookupASBulk returns AS numbers for all addresses, using one session per
+// BatchSize addresses
+func (w CymruWhois) LookupASBulk(ips []string) (map[string]int64, error) {
+	result := make(map[string]int64, len(ips))
+
+	batch := w.BatchSize
+	if batch <= 0 {
+		batch = len(ips)
+	}
+
+	for start := 0; start < len(ips); start += batch {
+		end := start + batch
+		if end > len(ips) {
+			end = len(ips)
+		}
+		if err := w.session(ips[start:end], result); nil != err {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+// session performs one begin/end bulk query and stores results
+func (w CymruWhois) session(ips []string, result map[string]int64) error {
+	server := w.Server
+	if "" == server {
+		server = DefaultCymruWhoisServer
+	}
+	timeout := w.Timeout
+	if 0 == timeout {
+		timeout = 30 * time.Second
+	}
+
+	// whois echoes addresses in canonical form, so map them back
+	requested := make(map[string]string, len(ips))
+	for _, ip := range ips {
+		if i := net.ParseIP(ip); nil != i {
+			requested[i.String()] = ip
+		}
+	}
+	if len(requested) == 0 {
+		return nil
+	}
+
+	conn, err := net.DialTimeout("tcp", server, timeout)
+	if nil != err {
+		return err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(timeout)); nil != err {
+		return err
+	}
+
+	go func() {
+		bw := bufio.NewWriter(conn)
+		bw.WriteString("begin\nverbose\n")
+		for ip := range requested {
+			bw.WriteString(ip + "\n")
+		}
+		bw.WriteString("end\n")
+		bw.Flush()
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		asnum, ip, ok := parseCymruWhoisLine(scanner.Text())
+		if !ok {
+			continue
+		}
+		orig, exist := requested[ip]
+		if !exist {
+			continue
+		}
+		if _, done := result[orig]; !done {
+			result[orig] = asnum
+		}
+	}
+
+	return scanner.Err()
+}
+
+// parseCymruWhoisLine parses "AS | IP | ..." line of bulk response,
+// header and unknown ("NA") lines aren't ok
+func parseCymruWhoisLine(line string) (int64, string, bool) {
+	parts := strings.Split(line, "|")
+	if len(parts) < 2 {
+		return -1, "", false
+	}
+
+	fields := strings.Fields(parts[0])
+	if len(fields) == 0 {
+		return -1, "", false
+	}
+
+	asnum, err := strconv.ParseInt(fields[0], 10, 64)
+	if nil != err {
+		return -1, "", false
+	}
+
+	ip := net.ParseIP(strings.TrimSpace(parts[1]))
+	if nil == ip {
+		return -1, "", false
+	}
+
+	return asnum, ip.String(), true
+}
+
+// CymruWhoisServer is local stand-in for Team Cymru whois bulk service
+// answering from any ASResolver, useful for testing and offline setups
+type CymruWhoisServer struct {
+	Resolver ASResolver
+}
+
+// Serve accepts connections on l until it's closed
+func (s *CymruWhoisServer) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if nil != err {
+			return err
+		}
+		go s.handle(conn)
+	}
+}
+
+// handle serves one whois session in bulk or single query mode
+func (s *CymruWhoisServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	w := bufio.NewWriter(conn)
+	defer w.Flush()
+
+	bulk := false
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch line {
+		case "":
+			continue
+		case "begin":
+			bulk = true
+			fmt.Fprintf(w, "Bulk mode; whois.cymru.com [%s]\n", time.Now().UTC().Format("2006-01-02 15:04:05 -0700"))
+			continue
+		case "end":
+			return
+		case "verbose", "noasname", "asname", "header", "noheader", "prefix", "noprefix", "cc", "nocc", "registry", "noregistry", "allocdate", "noallocdate", "asnumber", "noasnumber":
+			continue
+		}
+
+		// single query line may contain flags like " -v 1.2.3.4"
+		fields := strings.Fields(line)
+		s.answer(w, fields[len(fields)-1])
+
+		if !bulk {
+			return
+		}
+	}
+}
+
+// answer writes one result line
+func (s *CymruWhoisServer) answer(w io.Writer, ip string) {
+	i := net.ParseIP(ip)
+	if nil == i {
+		fmt.Fprintf(w, "Error: no ASN or IP match on line.\n")
+		return
+	}
+
+	as := "NA"
+	if nil != s.Resolver {
+		if asnum, err := s.Resolver.LookupAS(i.String()); nil == err && asnum >= 0 {
+			as = strconv.FormatInt(asnum, 10)
+		}
+	}
+
+	fmt.Fprintf(w, "%-8s| %-16s | %-19s | %-2s | %-8s | %-10s | %s\n", as, i.String(), "NA", "", "", "", "NA")
+}
@@ -0,0 +1,98 @@
+package tracelib
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+)
+
+// mapResolver answers from static map and counts lookups
+type mapResolver struct {
+	as      map[string]int64
+	lookups *int32
+}
+
+func (r mapResolver) LookupAS(ip string) (int64, error) {
+	atomic.AddInt32(r.lookups, 1)
+	if asnum, ok := r.as[ip]; ok {
+		return asnum, nil
+	}
+	return -1, ErrASNotFound
+}
+
+// startWhois runs CymruWhoisServer on random local port
+func startWhois(t *testing.T, r ASResolver) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go (&CymruWhoisServer{Resolver: r}).Serve(l)
+
+	return l.Addr().String()
+}
+
+func TestCymruWhoisBulk(t *testing.T) {
+	var lookups int32
+	server := startWhois(t, mapResolver{
+		as:      map[string]int64{"8.8.8.8": 15169, "2001:4860:4860::8888": 15169, "1.1.1.1": 13335},
+		lookups: &lookups,
+	})
+
+	w := CymruWhois{Server: server, BatchSize: 2}
+	// non-canonical IPv6 form must be mapped back to requested string
+	result, err := w.LookupASBulk([]string{"8.8.8.8", "2001:4860:4860:0::8888", "1.1.1.1", "192.0.2.1"})
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	expected := map[string]int64{"8.8.8.8": 15169, "2001:4860:4860:0::8888": 15169, "1.1.1.1": 13335}
+	if len(result) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, result)
+	}
+	for ip, asnum := range expected {
+		if result[ip] != asnum {
+			t.Errorf("%s: expected AS%d, got AS%d", ip, asnum, result[ip])
+		}
+	}
+}
+
+func TestPrefetchASCachesUnknown(t *testing.T) {
+	var lookups int32
+	server := startWhois(t, mapResolver{as: map[string]int64{"8.8.8.8": 15169}, lookups: &lookups})
+
+	cache := NewLookupCache()
+	cache.ASResolver = CymruWhois{Server: server}
+
+	if err := cache.PrefetchAS([]string{"8.8.8.8", "5.5.5.5", "10.0.0.1"}); nil != err {
+		t.Fatal(err)
+	}
+	// private address isn't sent to server at all
+	if 2 != atomic.LoadInt32(&lookups) {
+		t.Fatalf("expected 2 lookups by server, got %d", atomic.LoadInt32(&lookups))
+	}
+
+	if asnum := cache.LookupAS("8.8.8.8"); 15169 != asnum {
+		t.Errorf("expected AS15169, got %d", asnum)
+	}
+	if asnum := cache.LookupAS("5.5.5.5"); -1 != asnum {
+		t.Errorf("expected -1 for unannounced address, got %d", asnum)
+	}
+	if 2 != atomic.LoadInt32(&lookups) {
+		t.Errorf("cached addresses were looked up again, %d lookups", atomic.LoadInt32(&lookups))
+	}
+}
+
+func TestCymruWhoisUnreachable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	server := l.Addr().String()
+	l.Close()
+
+	if _, err := (CymruWhois{Server: server}).LookupASBulk([]string{"8.8.8.8"}); nil == err {
+		t.Error("expected error of closed server")
+	}
+}
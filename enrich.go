@@ -0,0 +1,127 @@
+package tracelib
+
+import (
+	"sync"
+)
+
+// DefaultEnrichWorkers is number of concurrent lookups used by Run* functions
+const DefaultEnrichWorkers = 16
+
+// classifier returns classifier of cache, nil cache is allowed
+func (cache *LookupCache) classifier() *Classifier {
+	if nil == cache {
+		return nil
+	}
+	return cache.Classifier
+}
+
+// enrichHop fills address class, hostname and AS number of one hop
+func (cache *LookupCache) enrichHop(hop *Hop) {
+	if nil == hop.Addr {
+		return
+	}
+
+	hop.Class = cache.classifier().Classify(addrIP(hop.Addr))
+	if nil == cache {
+		return
+	}
+
+	addrString := hop.Addr.String()
+	info := cache.LookupHostInfo(addrString)
+	hop.Host = info.Host
+	hop.HostConfirmed = info.Confirmed
+	hop.AS = cache.LookupAS(addrString)
+}
+
+// EnrichHops classifies addresses of all hops and fills Host and AS using
+// cache (if not nil); every unique address is looked up only once using up
+// to workers concurrent lookups; error of bulk AS prefetch is returned, hops
+// are still enriched by resolving remaining addresses one by one
+func EnrichHops(hops [][]Hop, cache *LookupCache, workers int) error {
+	classifier := cache.classifier()
+	for i := range hops {
+		for j := range hops[i] {
+			if nil != hops[i][j].Addr {
+				hops[i][j].Class = classifier.Classify(addrIP(hops[i][j].Addr))
+			}
+		}
+	}
+
+	if nil == cache {
+		return nil
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	type result struct {
+		host HostInfo
+		as   int64
+	}
+
+	addrs := make([]string, 0, len(hops))
+	results := map[string]*result{}
+
+	for _, hop := range hops {
+		for _, h := range hop {
+			if nil == h.Addr {
+				continue
+			}
+			addrString := h.Addr.String()
+			if _, ok := results[addrString]; !ok {
+				results[addrString] = &result{}
+				addrs = append(addrs, addrString)
+			}
+		}
+	}
+
+	// bulk capable backends resolve all AS numbers in one request, on error
+	// remaining addresses are just resolved one by one
+	prefetchErr := cache.PrefetchAS(addrs)
+
+	if workers > len(addrs) {
+		workers = len(addrs)
+	}
+
+	queue := make(chan string)
+	var wg sync.WaitGroup
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for addr := range queue {
+				// results map isn't modified anymore, so only values are written
+				r := results[addr]
+				r.host = cache.LookupHostInfo(addr)
+				r.as = cache.LookupAS(addr)
+			}
+		}()
+	}
+
+	for _, addr := range addrs {
+		queue <- addr
+	}
+	close(queue)
+	wg.Wait()
+
+	for i := range hops {
+		for j := range hops[i] {
+			if nil == hops[i][j].Addr {
+				continue
+			}
+			r := results[hops[i][j].Addr.String()]
+			hops[i][j].Host = r.host.Host
+			hops[i][j].HostConfirmed = r.host.Confirmed
+			hops[i][j].AS = r.as
+		}
+	}
+
+	return prefetchErr
+}
+
+// enrichTrace is EnrichHops of Run* functions; error of bulk AS prefetch is
+// ignored, addresses it failed for are resolved by ASResolver one by one
+func enrichTrace(hops [][]Hop, cache *LookupCache) {
+	EnrichHops(hops, cache, DefaultEnrichWorkers)
+}
@@ -15,7 +15,9 @@ func main() {
 
 	if nil != err {
 		fmt.Println("Traceroute error:", err)
-		return
+		if _, partial := err.(tracelib.HostErrors); !partial {
+			return
+		}
 	}
 
 	// fmt.Printf("%+v\n", rawHops)
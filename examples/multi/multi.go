@@ -19,24 +19,27 @@ func main() {
 		return
 	}
 
-	hops := tracelib.AggregateMulti(rawHops)
+	hops := tracelib.AggregateHops(rawHops)
 
 	for i, hop := range hops {
 		isd := fmt.Sprintf("%d. ", i+1)
 		isp := strings.Repeat(" ", len(isd))
 
-		for j, h := range hop {
+		for j, h := range hop.Responders {
 			prefix := isd
 			if j > 0 {
 				prefix = isp
 			}
 
-			if nil != h.Addr {
-				fmt.Printf("%s%v(%s)/AS%d %v/%v/%v (final:%v lost %d of %d, down %d of %d)\n", prefix, h.Host, h.Addr, h.AS, h.MinRTT, h.AvgRTT, h.MaxRTT, h.Final, h.Lost, h.Total, h.Down, h.Total)
-			} else {
-				fmt.Printf("%s Lost: %d\n", prefix, h.Lost)
-			}
+			fmt.Printf("%s%v(%s)/AS%d %v/%v/%v (final:%v lost %d of %d, down %d of %d)\n", prefix, h.Host, h.Addr, h.AS, h.MinRTT, h.AvgRTT, h.MaxRTT, h.Final, h.Lost, h.Total, h.Down, h.Total)
+		}
 
+		if hop.Lost > 0 {
+			prefix := isd
+			if len(hop.Responders) > 0 {
+				prefix = isp
+			}
+			fmt.Printf("%s Lost: %d of %d\n", prefix, hop.Lost, hop.Total)
 		}
 	}
 }
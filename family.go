@@ -0,0 +1,255 @@
+package tracelib
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// AddrFamily is address family policy of destination selection
+type AddrFamily int
+
+const (
+	// FamilyPreferV4 uses first IPv4 address of host, IPv6 one only if
+	// there is none
+	FamilyPreferV4 AddrFamily = iota
+	// FamilyPreferV6 uses first IPv6 address of host, IPv4 one only if
+	// there is none
+	FamilyPreferV6
+	// FamilyV4Only uses only IPv4 addresses
+	FamilyV4Only
+	// FamilyV6Only uses only IPv6 addresses
+	FamilyV6Only
+	// FamilyBoth traces both families, supported only by RunDualTrace
+	FamilyBoth
+)
+
+func (f AddrFamily) String() string {
+	switch f {
+	case FamilyPreferV4:
+		return "prefer-v4"
+	case FamilyPreferV6:
+		return "prefer-v6"
+	case FamilyV4Only:
+		return "v4-only"
+	case FamilyV6Only:
+		return "v6-only"
+	case FamilyBoth:
+		return "both"
+	}
+	return "invalid"
+}
+
+// ErrFamilyBoth is returned by single-family functions for FamilyBoth
+var ErrFamilyBoth = errors.New("FamilyBoth is supported only by RunDualTrace")
+
+// DefaultTraceWorkers is number of concurrent traces of RunAllAddrsTrace
+const DefaultTraceWorkers = 8
+
+// errUnresolved is returned if host has no address of requested family
+var errUnresolved = errors.New("Unable to resolve destination host")
+
+// selectDest returns address of addrList according to family
+func selectDest(addrList []net.IP, family AddrFamily) (net.IP, error) {
+	var first4, first6 net.IP
+	for _, addr := range addrList {
+		if nil != addr.To4() {
+			if nil == first4 {
+				first4 = addr
+			}
+		} else if nil != addr.To16() && nil == first6 {
+			first6 = addr
+		}
+	}
+
+	var dest net.IP
+	switch family {
+	case FamilyPreferV4:
+		dest = first4
+		if nil == dest {
+			dest = first6
+		}
+	case FamilyPreferV6:
+		dest = first6
+		if nil == dest {
+			dest = first4
+		}
+	case FamilyV4Only:
+		dest = first4
+	case FamilyV6Only:
+		dest = first6
+	case FamilyBoth:
+		return nil, ErrFamilyBoth
+	}
+
+	if nil == dest {
+		return nil, errUnresolved
+	}
+	return dest, nil
+}
+
+// resolveDest resolves host and returns destination address for raw ICMP
+// socket according to family and if it's IPv6 one
+func resolveDest(host string, family AddrFamily, resolver Resolver) (*net.IPAddr, bool, error) {
+	addrList, err := lookupHost(host, resolver)
+	if nil != err {
+		return nil, false, err
+	}
+
+	dest, err := selectDest(addrList, family)
+	if nil != err {
+		return nil, false, err
+	}
+
+	return &net.IPAddr{IP: dest}, nil == dest.To4(), nil
+}
+
+// DualTraceResult is result of RunDualTrace; result of family is nil with
+// error stored in IPv4Err or IPv6Err if host has no address of this family
+// or trace failed
+type DualTraceResult struct {
+	IPv4    *TraceResult
+	IPv6    *TraceResult
+	IPv4Err error
+	IPv6Err error
+}
+
+// RunDualTrace preforms RunMultiTraceOpts to IPv4 and IPv6 address of host
+// concurrently (Family of opts is ignored), cb is called from both traces
+// concurrently; error is returned only if both traces failed
+func RunDualTrace(host string, source string, source6 string, maxrtt time.Duration, maxttl int, DNScache *LookupCache, rounds int, cb Callback, opts *Options) (*DualTraceResult, error) {
+	addrList, err := lookupHost(host, opts.resolver())
+	if nil != err {
+		return nil, err
+	}
+
+	var (
+		result DualTraceResult
+		wg     sync.WaitGroup
+	)
+
+	run := func(family AddrFamily, res **TraceResult, resErr *error) {
+		defer wg.Done()
+
+		dest, err := selectDest(addrList, family)
+		if nil != err {
+			*resErr = err
+			return
+		}
+
+		var o Options
+		if nil != opts {
+			o = *opts
+		}
+		o.Family = family
+
+		*res, *resErr = RunMultiTraceOpts(dest.String(), source, source6, maxrtt, maxttl, DNScache, rounds, cb, &o)
+	}
+
+	wg.Add(2)
+	go run(FamilyV4Only, &result.IPv4, &result.IPv4Err)
+	go run(FamilyV6Only, &result.IPv6, &result.IPv6Err)
+	wg.Wait()
+
+	if nil != result.IPv4Err && nil != result.IPv6Err {
+		return nil, errors.New("IPv4: " + result.IPv4Err.Error() + ", IPv6: " + result.IPv6Err.Error())
+	}
+
+	return &result, nil
+}
+
+// AllTraceResult is result of RunAllAddrsTrace, Results and Errors are keyed
+// by address
+type AllTraceResult struct {
+	Results map[string]*TraceResult
+	Errors  map[string]error
+}
+
+// filterFamily returns addresses of addrList allowed by family, prefer
+// policies and FamilyBoth allow all of them
+func filterFamily(addrList []net.IP, family AddrFamily) []net.IP {
+	result := make([]net.IP, 0, len(addrList))
+	for _, addr := range addrList {
+		is4 := nil != addr.To4()
+		if (FamilyV4Only == family && !is4) || (FamilyV6Only == family && is4) {
+			continue
+		}
+		result = append(result, addr)
+	}
+	return result
+}
+
+// RunAllAddrsTrace preforms RunMultiTraceOpts to every address of host
+// allowed by Family of opts (or to random sample of SampleAddrs of them)
+// using up to TraceWorkers concurrent traces, useful for round-robin DNS and
+// multi-region endpoints; cb is called from all traces concurrently
+func RunAllAddrsTrace(host string, source string, source6 string, maxrtt time.Duration, maxttl int, DNScache *LookupCache, rounds int, cb Callback, opts *Options) (*AllTraceResult, error) {
+	addrList, err := lookupHost(host, opts.resolver())
+	if nil != err {
+		return nil, err
+	}
+
+	addrList = filterFamily(addrList, opts.family())
+	if 0 == len(addrList) {
+		return nil, errUnresolved
+	}
+
+	if nil != opts && opts.SampleAddrs > 0 && opts.SampleAddrs < len(addrList) {
+		sample := make([]net.IP, 0, opts.SampleAddrs)
+		for _, i := range rand.Perm(len(addrList))[:opts.SampleAddrs] {
+			sample = append(sample, addrList[i])
+		}
+		addrList = sample
+	}
+
+	var (
+		o      Options
+		wg     sync.WaitGroup
+		mutex  sync.Mutex
+		result = AllTraceResult{Results: map[string]*TraceResult{}, Errors: map[string]error{}}
+	)
+	if nil != opts {
+		o = *opts
+	}
+
+	workers := opts.traceWorkers()
+	if workers > len(addrList) {
+		workers = len(addrList)
+	}
+
+	queue := make(chan net.IP)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for addr := range queue {
+				o := o
+				if nil == addr.To4() {
+					o.Family = FamilyV6Only
+				} else {
+					o.Family = FamilyV4Only
+				}
+
+				res, err := RunMultiTraceOpts(addr.String(), source, source6, maxrtt, maxttl, DNScache, rounds, cb, &o)
+
+				mutex.Lock()
+				if nil != err {
+					result.Errors[addr.String()] = err
+				} else {
+					result.Results[addr.String()] = res
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	for _, addr := range addrList {
+		queue <- addr
+	}
+	close(queue)
+	wg.Wait()
+
+	return &result, nil
+}
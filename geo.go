@@ -0,0 +1,202 @@
+package tracelib
+
+import (
+	"errors"
+	"net"
+)
+
+// Location represents geographic location of hop address
+type Location struct {
+	City        string
+	Country     string
+	CountryCode string
+	Latitude    float64
+	Longitude   float64
+	// HasCoordinates is false if only city/country is known
+	HasCoordinates bool
+	// Accuracy is radius around coordinates in km, 0 if unknown
+	Accuracy int
+	// Source describes where location comes from ("mmdb", "hostname")
+	Source string
+	// Confidence of location in range 0..1
+	Confidence float64
+}
+
+// GeoDB provides offline geolocation and AS numbers from MaxMind-format
+// databases (GeoLite2/GeoIP2 City, Country and ASN or compatible)
+type GeoDB struct {
+	dbs []*mmdbReader
+}
+
+// OpenGeoDB loads one or more MMDB files, e.g. City and ASN databases;
+// results of all databases are merged on lookup
+func OpenGeoDB(filenames ...string) (*GeoDB, error) {
+	if len(filenames) == 0 {
+		return nil, errors.New("No MMDB file specified")
+	}
+
+	db := &GeoDB{}
+	for _, filename := range filenames {
+		r, err := openMMDB(filename)
+		if nil != err {
+			return nil, errors.New(filename + ": " + err.Error())
+		}
+		db.dbs = append(db.dbs, r)
+	}
+
+	return db, nil
+}
+
+// lookup returns merged records of all databases for ip
+func (db *GeoDB) lookup(ip net.IP) map[string]interface{} {
+	var result map[string]interface{}
+
+	for _, r := range db.dbs {
+		rec, err := r.lookup(ip)
+		if nil != err {
+			continue
+		}
+		m, ok := rec.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if nil == result {
+			result = make(map[string]interface{}, len(m))
+		}
+		for k, v := range m {
+			if _, exist := result[k]; !exist {
+				result[k] = v
+			}
+		}
+	}
+
+	return result
+}
+
+// Location returns location of ip, nil if unknown
+func (db *GeoDB) Location(ip net.IP) *Location {
+	rec := db.lookup(ip)
+	if nil == rec {
+		return nil
+	}
+
+	loc := Location{Source: "mmdb"}
+	found := false
+
+	if city, ok := rec["city"].(map[string]interface{}); ok {
+		loc.City = mmdbName(city)
+		found = found || "" != loc.City
+	}
+
+	country, ok := rec["country"].(map[string]interface{})
+	if !ok {
+		country, ok = rec["registered_country"].(map[string]interface{})
+	}
+	if ok {
+		loc.Country = mmdbName(country)
+		loc.CountryCode, _ = country["iso_code"].(string)
+		found = found || "" != loc.CountryCode
+	}
+
+	if l, ok := rec["location"].(map[string]interface{}); ok {
+		lat, okLat := l["latitude"].(float64)
+		lon, okLon := l["longitude"].(float64)
+		if okLat && okLon {
+			loc.Latitude = lat
+			loc.Longitude = lon
+			loc.HasCoordinates = true
+			found = true
+		}
+		if r, ok := l["accuracy_radius"].(uint64); ok {
+			loc.Accuracy = int(r)
+		}
+	}
+
+	if !found {
+		return nil
+	}
+
+	switch {
+	case "" != loc.City && loc.Accuracy > 0 && loc.Accuracy <= 100:
+		loc.Confidence = 0.8
+	case "" != loc.City:
+		loc.Confidence = 0.6
+	default:
+		loc.Confidence = 0.3
+	}
+
+	return &loc
+}
+
+// mmdbName returns english name from "names" map
+func mmdbName(m map[string]interface{}) string {
+	names, ok := m["names"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	name, _ := names["en"].(string)
+	return name
+}
+
+// LookupAS implements ASResolver using autonomous_system_number of ASN database
+func (db *GeoDB) LookupAS(ip string) (int64, error) {
+	i := net.ParseIP(ip)
+	if nil == i {
+		return -1, errors.New("Invalid IP address " + ip)
+	}
+
+	rec := db.lookup(i)
+	if asnum, ok := rec["autonomous_system_number"].(uint64); ok {
+		return int64(asnum), nil
+	}
+
+	return -1, ErrASNotFound
+}
+
+// LookupASOrg returns name of organization owning AS of ip
+func (db *GeoDB) LookupASOrg(ip net.IP) string {
+	org, _ := db.lookup(ip)["autonomous_system_organization"].(string)
+	return org
+}
+
+// EnrichHops sets Location of all hops with address
+func (db *GeoDB) EnrichHops(hops [][]Hop) {
+	for i := range hops {
+		for j := range hops[i] {
+			if ip := addrIP(hops[i][j].Addr); nil != ip {
+				hops[i][j].Location = db.Location(ip)
+			}
+		}
+	}
+}
+
+// EnrichMulti sets Location of all aggregated hops with address
+func (db *GeoDB) EnrichMulti(hops [][]MHop) {
+	for i := range hops {
+		for j := range hops[i] {
+			if ip := addrIP(hops[i][j].Addr); nil != ip {
+				hops[i][j].Location = db.Location(ip)
+			}
+		}
+	}
+}
+
+// addrIP extracts IP from hop address, nil if there is no address
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case nil:
+		return nil
+	case *net.IPAddr:
+		if nil != a {
+			return a.IP
+		}
+		return nil
+	case *net.UDPAddr:
+		if nil != a {
+			return a.IP
+		}
+		return nil
+	}
+
+	return net.ParseIP(addr.String())
+}
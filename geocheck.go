@@ -0,0 +1,77 @@
+package tracelib
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// FibreSpeed is propagation speed of light in fibre in km per millisecond
+	FibreSpeed = 200.0
+
+	earthRadius = 6371.0
+)
+
+// GeoCheck is result of comparison of measured RTT with hop location
+type GeoCheck struct {
+	// Distance is great-circle distance from source to hop location in km
+	Distance float64
+	// MinPossibleRTT is RTT of direct fibre path to hop location and back
+	MinPossibleRTT time.Duration
+	// Inflation is ratio of measured MinRTT to MinPossibleRTT, 0 if unknown
+	Inflation float64
+	// Impossible is true if measured RTT is lower than propagation delay
+	// even when location accuracy is taken into account
+	Impossible bool
+}
+
+// Distance returns great-circle distance between locations in km
+func (l *Location) Distance(to *Location) float64 {
+	lat1 := l.Latitude * math.Pi / 180
+	lat2 := to.Latitude * math.Pi / 180
+	dLat := lat2 - lat1
+	dLon := (to.Longitude - l.Longitude) * math.Pi / 180
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(math.Min(1, a)))
+}
+
+// checkLocation compares rtt to location with propagation delay from source
+func checkLocation(source *Location, loc *Location, rtt time.Duration) *GeoCheck {
+	if nil == loc || !loc.HasCoordinates || rtt <= 0 {
+		return nil
+	}
+
+	var check GeoCheck
+	check.Distance = source.Distance(loc)
+	check.MinPossibleRTT = time.Duration(2 * check.Distance / FibreSpeed * float64(time.Millisecond))
+
+	if check.MinPossibleRTT > 0 {
+		check.Inflation = float64(rtt) / float64(check.MinPossibleRTT)
+	}
+
+	// both locations may be off by their accuracy radius
+	slack := float64(source.Accuracy + loc.Accuracy)
+	if check.Distance > slack {
+		limit := time.Duration(2 * (check.Distance - slack) / FibreSpeed * float64(time.Millisecond))
+		check.Impossible = rtt < limit
+	}
+
+	return &check
+}
+
+// CheckGeoRTT sets GeoCheck and HintCheck of aggregated hops by comparing
+// MinRTT with distance from source to Location and LocationHint
+func CheckGeoRTT(hops [][]MHop, source *Location) {
+	if nil == source || !source.HasCoordinates {
+		return
+	}
+
+	for i := range hops {
+		for j := range hops[i] {
+			h := &hops[i][j]
+			h.GeoCheck = checkLocation(source, h.Location, h.MinRTT)
+			h.HintCheck = checkLocation(source, h.LocationHint, h.MinRTT)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package tracelib
+
+import (
+	"encoding/json"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// HintCodeType is kind of location code encoded in router hostname
+type HintCodeType string
+
+const (
+	// HintIATA is 3 letter IATA airport/city code (fra, ams, iad)
+	HintIATA HintCodeType = "iata"
+	// HintCLLI is 6 letter CLLI-style code (frnkge, nycmny)
+	HintCLLI HintCodeType = "clli"
+	// HintCity is full city name (Frankfurt1, newyork)
+	HintCity HintCodeType = "city"
+)
+
+// HintRule extracts location code from hostnames of one operator
+type HintRule struct {
+	// Operator is name of network for reporting
+	Operator string `json:"operator"`
+	// Domain limits rule to hostnames with this suffix, empty matches all
+	Domain string `json:"domain"`
+	// Pattern is regexp applied to lowercase hostname, first submatch is code
+	Pattern string `json:"pattern"`
+	// Type of extracted code
+	Type HintCodeType `json:"type"`
+	// Codes maps operator specific codes to IATA codes (e.g. "ffm": "fra")
+	Codes map[string]string `json:"codes,omitempty"`
+	// Confidence of location in range 0..1 if rule matches
+	Confidence float64 `json:"confidence"`
+
+	re *regexp.Regexp
+}
+
+// hintRoles are router role labels used by generic rules, bare "r" only
+// with number (r01)
+const hintRoles = `(?:(?:core|cr|br|ar|er|pe|bb|bbr|edge|agg|rtr|border)\d*|r\d+)`
+
+// DefaultHintRules are bundled rules for some well-known backbones and
+// low confidence generic IATA rules, which require code next to router role
+// label (r01.fra01, cr1.fra2, fra-core-1) to avoid matching ordinary words
+var DefaultHintRules = []HintRule{
+	{Operator: "Cogent", Domain: "cogentco.com", Pattern: `\.([a-z]{3})\d*\.atlas\.cogentco\.com\.?$`, Type: HintIATA, Confidence: 0.9},
+	{Operator: "Hurricane Electric", Domain: "he.net", Pattern: `\.core\d+\.([a-z]{3})\d*\.he\.net\.?$`, Type: HintIATA, Confidence: 0.9},
+	{Operator: "GTT", Domain: "gtt.net", Pattern: `\.cr\d+-([a-z]{3})\d*\.ip[46]\.gtt\.net\.?$`, Type: HintIATA, Confidence: 0.9},
+	{Operator: "Zayo", Domain: "zayo.com", Pattern: `\.([a-z]{3})\d*\.[a-z]{2}\.eth\.zayo\.com\.?$`, Type: HintIATA, Confidence: 0.9},
+	{Operator: "NTT", Domain: "ntt.net", Pattern: `\.([a-z]{6})\d*\.[a-z]{2}\.bb\.gin\.ntt\.net\.?$`, Type: HintCLLI, Confidence: 0.9},
+	{Operator: "Lumen", Domain: "level3.net", Pattern: `\.([a-z]+)\d*\.level3\.net\.?$`, Type: HintCity, Confidence: 0.8},
+	{Operator: "Arelion", Domain: "twelve99.net", Pattern: `^([a-z]+)-[a-z]+\d+`, Type: HintIATA, Confidence: 0.85,
+		Codes: map[string]string{"ffm": "fra", "ldn": "lon", "nyk": "nyc", "ash": "iad", "chi": "chi", "sjo": "sjc", "adm": "ams", "prs": "par", "kbn": "cph", "hbg": "ham", "mno": "mil", "prag": "prg", "bpt": "bud", "ww": "waw", "s": "sto", "hls": "hel", "sea": "sea", "dls": "dal", "atl": "atl", "mai": "mia", "lax": "lax", "vie": "vie", "mad": "mad", "zch": "zrh", "dln": "dub", "bcn": "bcn", "mcs": "mow"}},
+	{Operator: "Arelion", Domain: "telia.net", Pattern: `^([a-z]+)-[a-z]+\d+`, Type: HintIATA, Confidence: 0.85,
+		Codes: map[string]string{"ffm": "fra", "ldn": "lon", "nyk": "nyc", "ash": "iad", "adm": "ams", "prs": "par", "kbn": "cph", "prag": "prg", "s": "sto", "hls": "hel", "sjo": "sjc", "dls": "dal"}},
+	{Operator: "", Domain: "", Pattern: `(?:^|[.-])` + hintRoles + `[.-]([a-z]{3})\d{0,2}[.-]`, Type: HintIATA, Confidence: 0.3},
+	{Operator: "", Domain: "", Pattern: `(?:^|[.-])([a-z]{3})\d{0,2}[.-]` + hintRoles + `[.-]`, Type: HintIATA, Confidence: 0.3},
+}
+
+// HostHinter infers location of routers from their hostnames
+type HostHinter struct {
+	rules  []HintRule
+	cities map[string]HintPlace
+}
+
+// NewHostHinter compiles rules, DefaultHintRules are used if none specified
+func NewHostHinter(rules ...HintRule) (*HostHinter, error) {
+	if len(rules) == 0 {
+		rules = DefaultHintRules
+	}
+
+	h := &HostHinter{
+		rules:  make([]HintRule, 0, len(rules)),
+		cities: make(map[string]HintPlace, len(IATACodes)),
+	}
+
+	for _, r := range rules {
+		re, err := regexp.Compile(r.Pattern)
+		if nil != err {
+			return nil, err
+		}
+		r.re = re
+		r.Domain = strings.ToLower(strings.TrimSuffix(r.Domain, "."))
+		h.rules = append(h.rules, r)
+	}
+
+	for _, table := range []map[string]HintPlace{IATACodes, CLLICodes} {
+		for _, p := range table {
+			h.cities[cityKey(p.City)] = p
+		}
+	}
+
+	return h, nil
+}
+
+// LoadHintRules reads JSON array of HintRule
+func LoadHintRules(r io.Reader) ([]HintRule, error) {
+	var rules []HintRule
+	if err := json.NewDecoder(r).Decode(&rules); nil != err {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// cityKey normalizes city name for lookup ("New York" -> "newyork")
+func cityKey(city string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' {
+			return r
+		}
+		return -1
+	}, strings.ToLower(city))
+}
+
+// Hint returns location inferred from hostname by first matching rule,
+// nil if no rule matches known code
+func (h *HostHinter) Hint(host string) *Location {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if "" == host {
+		return nil
+	}
+
+	for _, r := range h.rules {
+		if "" != r.Domain && host != r.Domain && !strings.HasSuffix(host, "."+r.Domain) {
+			continue
+		}
+
+		for _, m := range r.re.FindAllStringSubmatch(host, -1) {
+			if len(m) < 2 {
+				continue
+			}
+			if place, ok := h.place(r, m[1]); ok {
+				return &Location{
+					City:           place.City,
+					CountryCode:    place.CountryCode,
+					Latitude:       place.Latitude,
+					Longitude:      place.Longitude,
+					HasCoordinates: true,
+					Accuracy:       50,
+					Source:         "hostname",
+					Confidence:     r.Confidence,
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// place resolves code extracted by rule
+func (h *HostHinter) place(r HintRule, code string) (HintPlace, bool) {
+	if c, ok := r.Codes[code]; ok {
+		code = c
+	}
+
+	var (
+		p  HintPlace
+		ok bool
+	)
+	switch r.Type {
+	case HintIATA:
+		p, ok = IATACodes[code]
+	case HintCLLI:
+		p, ok = CLLICodes[code]
+	case HintCity:
+		p, ok = h.cities[cityKey(code)]
+	}
+
+	return p, ok
+}
+
+// EnrichHops sets LocationHint of all hops with hostname
+func (h *HostHinter) EnrichHops(hops [][]Hop) {
+	for i := range hops {
+		for j := range hops[i] {
+			hops[i][j].LocationHint = h.Hint(hops[i][j].Host)
+		}
+	}
+}
+
+// EnrichMulti sets LocationHint of all aggregated hops with hostname
+func (h *HostHinter) EnrichMulti(hops [][]MHop) {
+	for i := range hops {
+		for j := range hops[i] {
+			hops[i][j].LocationHint = h.Hint(hops[i][j].Host)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package tracelib
+
+import "testing"
+
+func TestHostHinterDefaultRules(t *testing.T) {
+	h, err := NewHostHinter()
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		host string
+		city string // empty if no hint is expected
+	}{
+		// operator rules
+		{"be2960.ccr42.fra03.atlas.cogentco.com", "Frankfurt"},
+		{"100ge14-1.core1.ams1.he.net", "Amsterdam"},
+		{"ae5.cr4-lax2.ip4.gtt.net", "Los Angeles"},
+		{"ae12.cs1.fra6.de.eth.zayo.com", "Frankfurt"},
+		{"ae-5.r24.frnkge13.de.bb.gin.ntt.net", "Frankfurt"},
+		{"ae-1-3104.ear1.Frankfurt1.Level3.net.", "Frankfurt"},
+		{"ffm-bb2-link.ip.twelve99.net", "Frankfurt"},
+		// generic rules with router role next to code
+		{"ae-1.r01.fra01.example.net", "Frankfurt"},
+		{"be2.core1.ams.example.org", "Amsterdam"},
+		{"xe-0.mad-edge2.example.es", "Madrid"},
+		{"et-0-0-1.cr1.lax2.example.com", "Los Angeles"},
+		// ordinary words and labels without router role
+		{"man.example.com", ""},
+		{"mail.sea.example.org", ""},
+		{"gw1.lax.example.net", ""},
+		{"mad.example.com", ""},
+		{"www.lax-parking.example.com", ""},
+		{"r.fra.example.net", ""},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		loc := h.Hint(test.host)
+		switch {
+		case "" == test.city && nil != loc:
+			t.Errorf("%s: expected no hint, got %s", test.host, loc.City)
+		case "" != test.city && nil == loc:
+			t.Errorf("%s: expected %s, got no hint", test.host, test.city)
+		case nil != loc && test.city != loc.City:
+			t.Errorf("%s: expected %s, got %s", test.host, test.city, loc.City)
+		}
+	}
+}
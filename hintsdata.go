@@ -0,0 +1,199 @@
+package tracelib
+
+// bundled location code tables used by HostHinter; coordinates are city
+// centres, which is good enough for router location hints
+
+// HintPlace is place location code in router name refers to
+type HintPlace struct {
+	City        string
+	CountryCode string
+	Latitude    float64
+	Longitude   float64
+}
+
+// IATACodes maps lowercase IATA airport/city codes to places
+var IATACodes = map[string]HintPlace{
+	// Europe
+	"ams": {"Amsterdam", "NL", 52.37, 4.90},
+	"ath": {"Athens", "GR", 37.98, 23.73},
+	"bcn": {"Barcelona", "ES", 41.39, 2.17},
+	"beg": {"Belgrade", "RS", 44.79, 20.45},
+	"ber": {"Berlin", "DE", 52.52, 13.40},
+	"bru": {"Brussels", "BE", 50.85, 4.35},
+	"bts": {"Bratislava", "SK", 48.15, 17.11},
+	"bud": {"Budapest", "HU", 47.50, 19.04},
+	"buh": {"Bucharest", "RO", 44.43, 26.10},
+	"cph": {"Copenhagen", "DK", 55.68, 12.57},
+	"dub": {"Dublin", "IE", 53.35, -6.26},
+	"dus": {"Dusseldorf", "DE", 51.23, 6.78},
+	"edi": {"Edinburgh", "GB", 55.95, -3.19},
+	"fra": {"Frankfurt", "DE", 50.11, 8.68},
+	"gva": {"Geneva", "CH", 46.20, 6.14},
+	"ham": {"Hamburg", "DE", 53.55, 9.99},
+	"hel": {"Helsinki", "FI", 60.17, 24.94},
+	"ist": {"Istanbul", "TR", 41.01, 28.98},
+	"kbp": {"Kyiv", "UA", 50.45, 30.52},
+	"iev": {"Kyiv", "UA", 50.45, 30.52},
+	"lis": {"Lisbon", "PT", 38.72, -9.14},
+	"lju": {"Ljubljana", "SI", 46.06, 14.51},
+	"lon": {"London", "GB", 51.51, -0.13},
+	"lhr": {"London", "GB", 51.51, -0.13},
+	"lux": {"Luxembourg", "LU", 49.61, 6.13},
+	"lys": {"Lyon", "FR", 45.76, 4.84},
+	"mad": {"Madrid", "ES", 40.42, -3.70},
+	"man": {"Manchester", "GB", 53.48, -2.24},
+	"mil": {"Milan", "IT", 45.46, 9.19},
+	"mxp": {"Milan", "IT", 45.46, 9.19},
+	"mow": {"Moscow", "RU", 55.76, 37.62},
+	"svo": {"Moscow", "RU", 55.76, 37.62},
+	"mrs": {"Marseille", "FR", 43.30, 5.37},
+	"muc": {"Munich", "DE", 48.14, 11.58},
+	"osl": {"Oslo", "NO", 59.91, 10.75},
+	"par": {"Paris", "FR", 48.86, 2.35},
+	"cdg": {"Paris", "FR", 48.86, 2.35},
+	"prg": {"Prague", "CZ", 50.08, 14.44},
+	"rix": {"Riga", "LV", 56.95, 24.11},
+	"rom": {"Rome", "IT", 41.90, 12.50},
+	"fco": {"Rome", "IT", 41.90, 12.50},
+	"sof": {"Sofia", "BG", 42.70, 23.32},
+	"sto": {"Stockholm", "SE", 59.33, 18.07},
+	"arn": {"Stockholm", "SE", 59.33, 18.07},
+	"str": {"Stuttgart", "DE", 48.78, 9.18},
+	"tll": {"Tallinn", "EE", 59.44, 24.75},
+	"vie": {"Vienna", "AT", 48.21, 16.37},
+	"vno": {"Vilnius", "LT", 54.69, 25.28},
+	"waw": {"Warsaw", "PL", 52.23, 21.01},
+	"zag": {"Zagreb", "HR", 45.81, 15.98},
+	"zrh": {"Zurich", "CH", 47.38, 8.54},
+	// North America
+	"atl": {"Atlanta", "US", 33.75, -84.39},
+	"bos": {"Boston", "US", 42.36, -71.06},
+	"chi": {"Chicago", "US", 41.88, -87.63},
+	"ord": {"Chicago", "US", 41.88, -87.63},
+	"clt": {"Charlotte", "US", 35.23, -80.84},
+	"dal": {"Dallas", "US", 32.78, -96.80},
+	"dfw": {"Dallas", "US", 32.78, -96.80},
+	"den": {"Denver", "US", 39.74, -104.99},
+	"dtw": {"Detroit", "US", 42.33, -83.05},
+	"ewr": {"Newark", "US", 40.74, -74.17},
+	"hou": {"Houston", "US", 29.76, -95.37},
+	"iah": {"Houston", "US", 29.76, -95.37},
+	"iad": {"Ashburn", "US", 39.04, -77.49},
+	"was": {"Washington", "US", 38.91, -77.04},
+	"jax": {"Jacksonville", "US", 30.33, -81.66},
+	"kcy": {"Kansas City", "US", 39.10, -94.58},
+	"mci": {"Kansas City", "US", 39.10, -94.58},
+	"las": {"Las Vegas", "US", 36.17, -115.14},
+	"lax": {"Los Angeles", "US", 34.05, -118.24},
+	"mia": {"Miami", "US", 25.76, -80.19},
+	"msp": {"Minneapolis", "US", 44.98, -93.27},
+	"nyc": {"New York", "US", 40.71, -74.01},
+	"jfk": {"New York", "US", 40.71, -74.01},
+	"lga": {"New York", "US", 40.71, -74.01},
+	"phl": {"Philadelphia", "US", 39.95, -75.17},
+	"phx": {"Phoenix", "US", 33.45, -112.07},
+	"pdx": {"Portland", "US", 45.52, -122.68},
+	"sjc": {"San Jose", "US", 37.34, -121.89},
+	"sfo": {"San Francisco", "US", 37.77, -122.42},
+	"sea": {"Seattle", "US", 47.61, -122.33},
+	"slc": {"Salt Lake City", "US", 40.76, -111.89},
+	"stl": {"St. Louis", "US", 38.63, -90.20},
+	"tpa": {"Tampa", "US", 27.95, -82.46},
+	"yul": {"Montreal", "CA", 45.50, -73.57},
+	"ymq": {"Montreal", "CA", 45.50, -73.57},
+	"yvr": {"Vancouver", "CA", 49.28, -123.12},
+	"yyz": {"Toronto", "CA", 43.65, -79.38},
+	"yto": {"Toronto", "CA", 43.65, -79.38},
+	"yyc": {"Calgary", "CA", 51.05, -114.07},
+	"mex": {"Mexico City", "MX", 19.43, -99.13},
+	"qro": {"Queretaro", "MX", 20.59, -100.39},
+	// South America
+	"bog": {"Bogota", "CO", 4.71, -74.07},
+	"bue": {"Buenos Aires", "AR", -34.60, -58.38},
+	"eze": {"Buenos Aires", "AR", -34.60, -58.38},
+	"for": {"Fortaleza", "BR", -3.73, -38.53},
+	"gru": {"Sao Paulo", "BR", -23.55, -46.63},
+	"sao": {"Sao Paulo", "BR", -23.55, -46.63},
+	"lim": {"Lima", "PE", -12.05, -77.04},
+	"rio": {"Rio de Janeiro", "BR", -22.91, -43.17},
+	"gig": {"Rio de Janeiro", "BR", -22.91, -43.17},
+	"scl": {"Santiago", "CL", -33.45, -70.67},
+	// Asia, Middle East
+	"bkk": {"Bangkok", "TH", 13.76, 100.50},
+	"bom": {"Mumbai", "IN", 19.08, 72.88},
+	"can": {"Guangzhou", "CN", 23.13, 113.26},
+	"cgk": {"Jakarta", "ID", -6.21, 106.85},
+	"jkt": {"Jakarta", "ID", -6.21, 106.85},
+	"del": {"Delhi", "IN", 28.61, 77.21},
+	"dxb": {"Dubai", "AE", 25.20, 55.27},
+	"hkg": {"Hong Kong", "HK", 22.32, 114.17},
+	"kix": {"Osaka", "JP", 34.69, 135.50},
+	"osa": {"Osaka", "JP", 34.69, 135.50},
+	"kul": {"Kuala Lumpur", "MY", 3.14, 101.69},
+	"maa": {"Chennai", "IN", 13.08, 80.27},
+	"mnl": {"Manila", "PH", 14.60, 120.98},
+	"nrt": {"Tokyo", "JP", 35.68, 139.69},
+	"hnd": {"Tokyo", "JP", 35.68, 139.69},
+	"tyo": {"Tokyo", "JP", 35.68, 139.69},
+	"pek": {"Beijing", "CN", 39.90, 116.41},
+	"sel": {"Seoul", "KR", 37.57, 126.98},
+	"icn": {"Seoul", "KR", 37.57, 126.98},
+	"sha": {"Shanghai", "CN", 31.23, 121.47},
+	"pvg": {"Shanghai", "CN", 31.23, 121.47},
+	"sin": {"Singapore", "SG", 1.35, 103.82},
+	"tlv": {"Tel Aviv", "IL", 32.09, 34.78},
+	"tpe": {"Taipei", "TW", 25.03, 121.57},
+	// Oceania, Africa
+	"akl": {"Auckland", "NZ", -36.85, 174.76},
+	"bne": {"Brisbane", "AU", -27.47, 153.03},
+	"mel": {"Melbourne", "AU", -37.81, 144.96},
+	"per": {"Perth", "AU", -31.95, 115.86},
+	"syd": {"Sydney", "AU", -33.87, 151.21},
+	"cai": {"Cairo", "EG", 30.04, 31.24},
+	"cpt": {"Cape Town", "ZA", -33.92, 18.42},
+	"jnb": {"Johannesburg", "ZA", -26.20, 28.05},
+	"los": {"Lagos", "NG", 6.52, 3.38},
+	"nbo": {"Nairobi", "KE", -1.29, 36.82},
+}
+
+// CLLICodes maps lowercase 6 character CLLI-style city codes (4 characters
+// of city and 2 of state or country) to places
+var CLLICodes = map[string]HintPlace{
+	"asbnva": {"Ashburn", "US", 39.04, -77.49},
+	"atlnga": {"Atlanta", "US", 33.75, -84.39},
+	"bstnma": {"Boston", "US", 42.36, -71.06},
+	"chcgil": {"Chicago", "US", 41.88, -87.63},
+	"dllstx": {"Dallas", "US", 32.78, -96.80},
+	"dnvrco": {"Denver", "US", 39.74, -104.99},
+	"hstntx": {"Houston", "US", 29.76, -95.37},
+	"lsanca": {"Los Angeles", "US", 34.05, -118.24},
+	"miamfl": {"Miami", "US", 25.76, -80.19},
+	"nwrknj": {"Newark", "US", 40.74, -74.17},
+	"nycmny": {"New York", "US", 40.71, -74.01},
+	"plalca": {"Palo Alto", "US", 37.44, -122.14},
+	"phlapa": {"Philadelphia", "US", 39.95, -75.17},
+	"phnxaz": {"Phoenix", "US", 33.45, -112.07},
+	"sttlwa": {"Seattle", "US", 47.61, -122.33},
+	"snjsca": {"San Jose", "US", 37.34, -121.89},
+	"snfcca": {"San Francisco", "US", 37.77, -122.42},
+	"stlsmo": {"St. Louis", "US", 38.63, -90.20},
+	"washdc": {"Washington", "US", 38.91, -77.04},
+	"tokyjp": {"Tokyo", "JP", 35.68, 139.69},
+	"osakjp": {"Osaka", "JP", 34.69, 135.50},
+	"sngpsg": {"Singapore", "SG", 1.35, 103.82},
+	"hkgchk": {"Hong Kong", "HK", 22.32, 114.17},
+	"sydnau": {"Sydney", "AU", -33.87, 151.21},
+	"londen": {"London", "GB", 51.51, -0.13},
+	"londgb": {"London", "GB", 51.51, -0.13},
+	"amstnl": {"Amsterdam", "NL", 52.37, 4.90},
+	"frnkge": {"Frankfurt", "DE", 50.11, 8.68},
+	"frnkde": {"Frankfurt", "DE", 50.11, 8.68},
+	"parsfr": {"Paris", "FR", 48.86, 2.35},
+	"mdrdsp": {"Madrid", "ES", 40.42, -3.70},
+	"milnit": {"Milan", "IT", 45.46, 9.19},
+	"vienat": {"Vienna", "AT", 48.21, 16.37},
+	"zrchch": {"Zurich", "CH", 47.38, 8.54},
+	"stckse": {"Stockholm", "SE", 59.33, 18.07},
+	"wrswpl": {"Warsaw", "PL", 52.23, 21.01},
+	"prgucz": {"Prague", "CZ", 50.08, 14.44},
+}
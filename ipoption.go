@@ -0,0 +1,209 @@
+package tracelib
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// IPOption is IPv4 option sent with echo request by ProbeIPOption
+type IPOption int
+
+const (
+	// OptionNone disables option probing
+	OptionNone IPOption = iota
+	// OptionRecordRoute records up to 9 addresses (RFC 791)
+	OptionRecordRoute
+	// OptionTimestamp records up to 4 addresses with timestamps (RFC 791,
+	// flag 1)
+	OptionTimestamp
+)
+
+// IP option types and lengths
+const (
+	ipOptEOL         = 0
+	ipOptNOP         = 1
+	ipOptRecordRoute = 7
+	ipOptTimestamp   = 68
+
+	rrSlots = 9
+	tsSlots = 4
+)
+
+// ErrIPv6Options is returned by ProbeIPOption for IPv6 destinations
+var ErrIPv6Options = errors.New("IP options are supported only for IPv4")
+
+// RecordedRoute is result of IP option probe
+type RecordedRoute struct {
+	Option IPOption
+	// Reached is true if echo reply was received
+	Reached bool
+	RTT     time.Duration
+	// Addrs are all addresses recorded by echo request and reply
+	Addrs []net.IP
+	// Timestamps are milliseconds since midnight UT recorded together
+	// with Addrs, OptionTimestamp only
+	Timestamps []uint32
+	// Forward and Reverse are addresses recorded before and after
+	// destination, Reverse is nil if destination address wasn't recorded
+	Forward []net.IP
+	Reverse []net.IP
+}
+
+// ProbeIPOption sends echo request with Record Route or Timestamp option to
+// IPv4 host and parses option returned in echo reply; only destinations
+// within 9 (or 4 for timestamps) hops can be reached with space left for
+// reverse path addresses
+func ProbeIPOption(host string, source string, maxrtt time.Duration, option IPOption) (*RecordedRoute, error) {
+	return probeIPOption(host, source, maxrtt, option, nil)
+}
+
+func probeIPOption(host string, source string, maxrtt time.Duration, option IPOption, opts *Options) (*RecordedRoute, error) {
+	var opt []byte
+	switch option {
+	case OptionRecordRoute:
+		// type, length, pointer, slots and EOL padding to 4 bytes
+		opt = make([]byte, 3+4*rrSlots+1)
+		opt[0], opt[1], opt[2] = ipOptRecordRoute, 3+4*rrSlots, 4
+	case OptionTimestamp:
+		opt = make([]byte, 4+8*tsSlots)
+		opt[0], opt[1], opt[2], opt[3] = ipOptTimestamp, byte(len(opt)), 5, 1
+	default:
+		return nil, errors.New("Unknown IP option")
+	}
+
+	t, err := newTrace(host, source, "", maxrtt, 0, opts)
+	if nil != err {
+		return nil, err
+	}
+	defer t.Close()
+
+	if nil != t.ipv6conn {
+		return nil, ErrIPv6Options
+	}
+
+	raw, err := ipv4.NewRawConn(t.conn)
+	if nil != err {
+		return nil, err
+	}
+
+	h := &ipv4.Header{
+		Version:  ipv4.Version,
+		Len:      ipv4.HeaderLen + len(opt),
+		TotalLen: ipv4.HeaderLen + len(opt) + len(t.netmsg),
+		TTL:      64,
+		Protocol: ProtocolICMP,
+		Dst:      t.dest.(*net.IPAddr).IP,
+		Options:  opt,
+	}
+
+	if err := raw.SetReadDeadline(time.Now().Add(maxrtt)); nil != err {
+		return nil, err
+	}
+
+	sendOn := time.Now()
+	if err := raw.WriteTo(h, t.netmsg, nil); nil != err {
+		return nil, err
+	}
+
+	result := &RecordedRoute{Option: option}
+	buf := make([]byte, 1500)
+	for {
+		rh, p, _, err := raw.ReadFrom(buf)
+		if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+			return result, nil
+		}
+		if nil != err {
+			return nil, err
+		}
+
+		msg, err := icmp.ParseMessage(ProtocolICMP, p)
+		if nil != err || ipv4.ICMPTypeEchoReply != msg.Type {
+			continue
+		}
+		if echo, ok := msg.Body.(*icmp.Echo); !ok || t.id != echo.ID {
+			continue
+		}
+
+		result.Reached = true
+		result.RTT = time.Since(sendOn)
+		result.Addrs, result.Timestamps = parseIPOptions(rh.Options)
+
+		dest := h.Dst
+		for i, ip := range result.Addrs {
+			if ip.Equal(dest) {
+				result.Forward = result.Addrs[:i+1]
+				result.Reverse = result.Addrs[i+1:]
+				break
+			}
+		}
+		if nil == result.Forward {
+			result.Forward = result.Addrs
+		}
+
+		return result, nil
+	}
+}
+
+// parseIPOptions returns addresses and timestamps from Record Route and
+// Timestamp options
+func parseIPOptions(b []byte) ([]net.IP, []uint32) {
+	var (
+		addrs []net.IP
+		ts    []uint32
+	)
+
+	for i := 0; i < len(b); {
+		switch b[i] {
+		case ipOptEOL:
+			return addrs, ts
+		case ipOptNOP:
+			i++
+			continue
+		}
+
+		if i+1 >= len(b) || b[i+1] < 2 || i+int(b[i+1]) > len(b) {
+			break
+		}
+		opt := b[i : i+int(b[i+1])]
+		i += len(opt)
+
+		if len(opt) < 4 {
+			continue
+		}
+		// pointer is 1-based offset of first free slot
+		end := int(opt[2]) - 1
+		if end > len(opt) {
+			end = len(opt)
+		}
+
+		switch opt[0] {
+		case ipOptRecordRoute:
+			for j := 3; j+4 <= end; j += 4 {
+				addrs = append(addrs, net.IP(append([]byte(nil), opt[j:j+4]...)))
+			}
+		case ipOptTimestamp:
+			withAddrs := 0 != opt[3]&0x0f
+			for j := 4; j < end; {
+				if withAddrs {
+					if j+8 > end {
+						break
+					}
+					addrs = append(addrs, net.IP(append([]byte(nil), opt[j:j+4]...)))
+					j += 4
+				}
+				if j+4 > end {
+					break
+				}
+				ts = append(ts, binary.BigEndian.Uint32(opt[j:j+4]))
+				j += 4
+			}
+		}
+	}
+
+	return addrs, ts
+}
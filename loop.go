@@ -0,0 +1,111 @@
+package tracelib
+
+import (
+	"net"
+)
+
+// Loop describes forwarding loop found in trace
+type Loop struct {
+	// Members are addresses forming one cycle of loop in order of hops
+	Members []net.Addr
+	// FirstHop is 1-based number of hop where loop starts
+	FirstHop int
+	// Period is number of hops in one cycle
+	Period int
+	// Repeats is number of complete cycles seen
+	Repeats int
+}
+
+// DetectLoop finds sequence of addresses repeated at least repeats times
+// in consecutive hops of result of RunTrace; single address has to be
+// repeated one more time as routers not decrementing TTL produce duplicates
+func DetectLoop(hops []Hop, repeats int) *Loop {
+	addrs := make([]net.Addr, len(hops))
+	for i, h := range hops {
+		addrs[i] = h.Addr
+	}
+
+	return detectLoop(addrs, repeats)
+}
+
+// DetectMultiLoop finds loop in result of RunMultiTrace, for every hop
+// most often responding address is used
+func DetectMultiLoop(hops [][]Hop, repeats int) *Loop {
+	addrs := make([]net.Addr, len(hops))
+	for i, hop := range hops {
+		counts := map[string]int{}
+		best := 0
+		for _, h := range hop {
+			if nil == h.Addr {
+				continue
+			}
+			k := h.Addr.String()
+			counts[k]++
+			if counts[k] > best {
+				best = counts[k]
+				addrs[i] = h.Addr
+			}
+		}
+	}
+
+	return detectLoop(addrs, repeats)
+}
+
+// detectLoop returns earliest and shortest repeated cycle of addresses
+func detectLoop(addrs []net.Addr, repeats int) *Loop {
+	if repeats < 2 {
+		repeats = 2
+	}
+
+	keys := make([]string, len(addrs))
+	for i, a := range addrs {
+		if nil != a {
+			keys[i] = a.String()
+		}
+	}
+
+	for start := 0; start < len(keys); start++ {
+		if "" == keys[start] {
+			continue
+		}
+
+		for period := 1; start+period*repeats <= len(keys); period++ {
+			need := repeats
+			if 1 == period {
+				need++
+			}
+
+			// count how many hops after start keep repeating the cycle
+			matched := period
+			for i := start + period; i < len(keys); i++ {
+				if "" == keys[i] || keys[i] != keys[i-period] {
+					break
+				}
+				matched++
+			}
+
+			cycles := matched / period
+			if cycles < need || !fullCycle(keys[start:start+period]) {
+				continue
+			}
+
+			loop := &Loop{FirstHop: start + 1, Period: period, Repeats: cycles}
+			for _, a := range addrs[start : start+period] {
+				loop.Members = append(loop.Members, a)
+			}
+			return loop
+		}
+	}
+
+	return nil
+}
+
+// fullCycle checks that every hop of cycle has address
+func fullCycle(keys []string) bool {
+	for _, k := range keys {
+		if "" == k {
+			return false
+		}
+	}
+	return true
+}
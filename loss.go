@@ -0,0 +1,202 @@
+package tracelib
+
+import (
+	"errors"
+	"time"
+)
+
+// LossKind is interpretation of packet loss at hop
+type LossKind int
+
+const (
+	// LossNone means hop has no loss
+	LossNone LossKind = iota
+	// LossRateLimited means loss doesn't persist to later hops, so it's
+	// most likely caused by ICMP rate limiting of router control plane
+	LossRateLimited
+	// LossPropagating means loss persists to later hops or destination,
+	// so packets are really dropped in forwarding plane
+	LossPropagating
+	// LossUnknown means there are no later hops to compare with
+	LossUnknown
+)
+
+func (k LossKind) String() string {
+	switch k {
+	case LossNone:
+		return "none"
+	case LossRateLimited:
+		return "likely rate-limited"
+	case LossPropagating:
+		return "propagating"
+	case LossUnknown:
+		return "unknown"
+	}
+	return "invalid"
+}
+
+// HopLoss is loss analysis result of one hop
+type HopLoss struct {
+	// Hop is 1-based hop number
+	Hop int
+	// Loss is percentage of probes without reply
+	Loss float64
+	// Downstream is lowest loss of later responding hops, -1 if none
+	Downstream float64
+	Kind       LossKind
+	// Reprobed is true if ReprobeLoss measured loss with slow probing
+	Reprobed    bool
+	ReprobeLoss float64
+}
+
+// hopLoss returns loss percentage of aggregated hop and if it has replies,
+// hop without responders lost all probes
+func hopLoss(hop []MHop) (float64, bool) {
+	if 0 == len(hop) {
+		return 100, false
+	}
+
+	responding := false
+	for _, h := range hop {
+		responding = responding || h.Total > h.Lost
+	}
+	return hop[0].Loss, responding
+}
+
+// AnalyzeLoss distinguishes control-plane rate limiting from forwarding loss
+// by checking whether loss of every hop persists to later hops and sets
+// LossKind of all responders; loss is considered propagating if all later
+// responding hops lose at least half as much
+func AnalyzeLoss(hops [][]MHop) []HopLoss {
+	result := make([]HopLoss, len(hops))
+
+	losses := make([]float64, len(hops))
+	responding := make([]bool, len(hops))
+	for i, hop := range hops {
+		losses[i], responding[i] = hopLoss(hop)
+	}
+
+	final := len(hops) > 0 && isFinalHop(hops[len(hops)-1])
+
+	// lowest loss of responding hops after each hop, -1 if there are none
+	downstream := -1.0
+	for i := len(hops) - 1; i >= 0; i-- {
+		r := HopLoss{Hop: i + 1, Loss: losses[i], Downstream: downstream}
+
+		switch {
+		case 0 == losses[i]:
+			r.Kind = LossNone
+		case downstream < 0:
+			if final && i == len(hops)-1 {
+				r.Kind = LossPropagating
+			} else {
+				r.Kind = LossUnknown
+			}
+		case downstream >= losses[i]/2:
+			r.Kind = LossPropagating
+		default:
+			r.Kind = LossRateLimited
+		}
+
+		result[i] = r
+		setLossKind(hops[i], r.Kind)
+
+		if responding[i] && (downstream < 0 || losses[i] < downstream) {
+			downstream = losses[i]
+		}
+	}
+
+	return result
+}
+
+func isFinalHop(hop []MHop) bool {
+	for _, h := range hop {
+		if h.Final {
+			return true
+		}
+	}
+	return false
+}
+
+func setLossKind(hop []MHop, kind LossKind) {
+	for j := range hop {
+		hop[j].LossKind = kind
+	}
+}
+
+// ProbeLoss sends count probes with specified TTL spaced by interval and
+// returns percentage of probes without any reply
+func ProbeLoss(host string, source string, source6 string, maxrtt time.Duration, ttl int, count int, interval time.Duration) (float64, error) {
+	if count < 1 {
+		return 0, errors.New("Probe count must be positive")
+	}
+
+	t, err := newTrace(host, source, source6, maxrtt, ttl, nil)
+	if nil != err {
+		return 0, err
+	}
+	defer t.Close()
+
+	return t.probeLoss(ttl, count, interval), nil
+}
+
+// probeLoss sends count probes with specified TTL spaced by interval
+func (t *trace) probeLoss(ttl int, count int, interval time.Duration) float64 {
+	lost := 0
+	for i := 0; i < count; i++ {
+		start := time.Now()
+		hop := t.Step(ttl)
+		if hop.Timeout || nil != hop.Error {
+			lost++
+		}
+		if i < count-1 {
+			time.Sleep(interval - time.Since(start))
+		}
+	}
+
+	return 100 * float64(lost) / float64(count)
+}
+
+// ReprobeLoss adaptively probes hops with rate-limited or unknown loss
+// kind again at slow rate; hop whose loss drops to less than half with
+// slow probing is confirmed as rate-limited, hop of unknown kind with
+// persisting loss is considered propagating. Host is resolved only once,
+// so traced address (TraceResult.Dest) should be passed for hosts with
+// several addresses
+func ReprobeLoss(host string, source string, source6 string, maxrtt time.Duration, hops [][]MHop, losses []HopLoss, count int, interval time.Duration) error {
+	if count < 1 {
+		return errors.New("Probe count must be positive")
+	}
+
+	var t *trace
+	for i := range losses {
+		l := &losses[i]
+		if LossRateLimited != l.Kind && LossUnknown != l.Kind {
+			continue
+		}
+
+		if nil == t {
+			var err error
+			if t, err = newTrace(host, source, source6, maxrtt, len(hops), nil); nil != err {
+				return err
+			}
+			defer t.Close()
+		}
+
+		loss := t.probeLoss(l.Hop, count, interval)
+
+		l.Reprobed = true
+		l.ReprobeLoss = loss
+		if loss < l.Loss/2 {
+			l.Kind = LossRateLimited
+		} else if LossUnknown == l.Kind {
+			l.Kind = LossPropagating
+		}
+
+		if l.Hop-1 < len(hops) {
+			setLossKind(hops[l.Hop-1], l.Kind)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,315 @@
+package tracelib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"net"
+)
+
+// minimal reader of MaxMind DB format (GeoLite2/GeoIP2 and compatible)
+// https://maxmind.github.io/MaxMind-DB/
+
+var (
+	mmdbMetadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+	errMMDBInvalid = errors.New("Invalid MaxMind DB file")
+)
+
+// mmdbMaxDepth limits nesting of maps, arrays and pointers, real databases
+// use just a few levels
+const mmdbMaxDepth = 32
+
+// mmdbReader holds whole database file in memory
+type mmdbReader struct {
+	buf        []byte
+	data       []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	dbType     string
+	ipv4Start  uint
+}
+
+// openMMDB reads and validates MaxMind DB file
+func openMMDB(filename string) (*mmdbReader, error) {
+	buf, err := ioutil.ReadFile(filename)
+	if nil != err {
+		return nil, err
+	}
+
+	return newMMDB(buf)
+}
+
+// newMMDB parses metadata of database loaded into buf
+func newMMDB(buf []byte) (*mmdbReader, error) {
+	pos := bytes.LastIndex(buf, mmdbMetadataMarker)
+	if pos < 0 {
+		return nil, errMMDBInvalid
+	}
+
+	metaBuf := buf[pos+len(mmdbMetadataMarker):]
+	meta, _, err := mmdbDecode(metaBuf, 0)
+	if nil != err {
+		return nil, err
+	}
+	metaMap, ok := meta.(map[string]interface{})
+	if !ok {
+		return nil, errMMDBInvalid
+	}
+
+	r := &mmdbReader{buf: buf}
+
+	var n uint64
+	if n, ok = metaMap["node_count"].(uint64); !ok {
+		return nil, errMMDBInvalid
+	}
+	r.nodeCount = uint(n)
+	if n, ok = metaMap["record_size"].(uint64); !ok {
+		return nil, errMMDBInvalid
+	}
+	r.recordSize = uint(n)
+	if n, ok = metaMap["ip_version"].(uint64); !ok {
+		return nil, errMMDBInvalid
+	}
+	r.ipVersion = uint(n)
+	r.dbType, _ = metaMap["database_type"].(string)
+
+	if 24 != r.recordSize && 28 != r.recordSize && 32 != r.recordSize {
+		return nil, errors.New("Unsupported MaxMind DB record size")
+	}
+
+	treeSize := r.nodeCount * r.recordSize / 4
+	if treeSize+16 > uint(pos) {
+		return nil, errMMDBInvalid
+	}
+	r.data = buf[treeSize+16 : pos]
+
+	// IPv4 addresses are stored in IPv6 tree under ::/96
+	if 6 == r.ipVersion {
+		node := uint(0)
+		for i := 0; i < 96 && node < r.nodeCount; i++ {
+			node = r.readNode(node, 0)
+		}
+		r.ipv4Start = node
+	}
+
+	return r, nil
+}
+
+// readNode returns left (bit=0) or right (bit=1) record of node
+func (r *mmdbReader) readNode(node uint, bit uint) uint {
+	b := r.buf[node*r.recordSize/4:]
+
+	switch r.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		if 0 == bit {
+			return uint(b[3]&0xF0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0F)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:]))
+	}
+}
+
+// lookup returns decoded record for ip, nil if address isn't in database
+func (r *mmdbReader) lookup(ip net.IP) (interface{}, error) {
+	node := uint(0)
+	bits := 128
+
+	if ip4 := ip.To4(); nil != ip4 {
+		ip = ip4
+		bits = 32
+		if 6 == r.ipVersion {
+			node = r.ipv4Start
+		}
+	} else if 4 == r.ipVersion {
+		return nil, nil
+	} else {
+		ip = ip.To16()
+		if nil == ip {
+			return nil, errors.New("Invalid IP address")
+		}
+	}
+
+	for i := 0; i < bits && node < r.nodeCount; i++ {
+		bit := uint(ip[i>>3]>>(7-uint(i&7))) & 1
+		node = r.readNode(node, bit)
+	}
+
+	if node <= r.nodeCount {
+		return nil, nil
+	}
+
+	offset := node - r.nodeCount - 16
+	if offset >= uint(len(r.data)) {
+		return nil, errMMDBInvalid
+	}
+
+	result, _, err := mmdbDecode(r.data, offset)
+	return result, err
+}
+
+// mmdbDecode decodes value at offset of data section and returns it with
+// offset of next value
+func mmdbDecode(data []byte, offset uint) (interface{}, uint, error) {
+	return mmdbDecodeDepth(data, offset, 0)
+}
+
+// mmdbDecodeDepth is mmdbDecode of value nested depth levels deep
+func mmdbDecodeDepth(data []byte, offset uint, depth int) (interface{}, uint, error) {
+	if offset >= uint(len(data)) || depth > mmdbMaxDepth {
+		return nil, 0, errMMDBInvalid
+	}
+
+	ctrl := data[offset]
+	offset++
+	typ := uint(ctrl >> 5)
+
+	if 1 == typ {
+		// pointer, value it points to is decoded but we continue after pointer
+		ss := uint(ctrl>>3) & 3
+		vvv := uint(ctrl & 7)
+		if offset+ss+1 > uint(len(data)) {
+			return nil, 0, errMMDBInvalid
+		}
+		var p uint
+		b := data[offset:]
+		switch ss {
+		case 0:
+			p = vvv<<8 | uint(b[0])
+		case 1:
+			p = (vvv<<16 | uint(b[0])<<8 | uint(b[1])) + 2048
+		case 2:
+			p = (vvv<<24 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])) + 526336
+		default:
+			p = uint(binary.BigEndian.Uint32(b))
+		}
+		// pointer to pointer is invalid by specification
+		if p >= uint(len(data)) || 1 == data[p]>>5 {
+			return nil, 0, errMMDBInvalid
+		}
+		value, _, err := mmdbDecodeDepth(data, p, depth+1)
+		return value, offset + ss + 1, err
+	}
+
+	if 0 == typ {
+		if offset >= uint(len(data)) {
+			return nil, 0, errMMDBInvalid
+		}
+		typ = 7 + uint(data[offset])
+		offset++
+	}
+
+	size := uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint(len(data)) {
+			return nil, 0, errMMDBInvalid
+		}
+		var v uint
+		for _, b := range data[offset : offset+n] {
+			v = v<<8 | uint(b)
+		}
+		switch size {
+		case 29:
+			size = 29 + v
+		case 30:
+			size = 285 + v
+		default:
+			size = 65821 + v
+		}
+		offset += n
+	}
+
+	// every map entry and array element takes at least one byte
+	if (7 == typ || 11 == typ) && size > uint(len(data))-offset {
+		return nil, 0, errMMDBInvalid
+	}
+
+	switch typ {
+	case 7: // map
+		result := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			key, next, err := mmdbDecodeDepth(data, offset, depth+1)
+			if nil != err {
+				return nil, 0, err
+			}
+			k, ok := key.(string)
+			if !ok {
+				return nil, 0, errMMDBInvalid
+			}
+			value, next, err := mmdbDecodeDepth(data, next, depth+1)
+			if nil != err {
+				return nil, 0, err
+			}
+			result[k] = value
+			offset = next
+		}
+		return result, offset, nil
+	case 11: // array
+		result := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			value, next, err := mmdbDecodeDepth(data, offset, depth+1)
+			if nil != err {
+				return nil, 0, err
+			}
+			result = append(result, value)
+			offset = next
+		}
+		return result, offset, nil
+	case 14: // boolean, value is stored in size
+		return 0 != size, offset, nil
+	}
+
+	if offset+size > uint(len(data)) {
+		return nil, 0, errMMDBInvalid
+	}
+	b := data[offset : offset+size]
+	offset += size
+
+	switch typ {
+	case 2: // utf8 string
+		return string(b), offset, nil
+	case 3: // double
+		if 8 != size {
+			return nil, 0, errMMDBInvalid
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case 4: // bytes
+		return append([]byte(nil), b...), offset, nil
+	case 5, 6, 9: // uint16, uint32, uint64
+		var v uint64
+		for _, c := range b {
+			v = v<<8 | uint64(c)
+		}
+		return v, offset, nil
+	case 8: // int32
+		var v uint32
+		for _, c := range b {
+			v = v<<8 | uint32(c)
+		}
+		if size < 4 {
+			// shorter values are not sign extended
+			return int64(v), offset, nil
+		}
+		return int64(int32(v)), offset, nil
+	case 10: // uint128
+		return new(big.Int).SetBytes(b), offset, nil
+	case 15: // float
+		if 4 != size {
+			return nil, 0, errMMDBInvalid
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), offset, nil
+	case 12, 13: // data cache container, end marker
+		return nil, offset, nil
+	}
+
+	return nil, 0, errMMDBInvalid
+}
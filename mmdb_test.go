@@ -0,0 +1,89 @@
+package tracelib
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+// testMMDB builds IPv4 database of one node with 0.0.0.0/1 pointing to
+// {"cc": "CZ"}, country code is stored behind pointer
+func testMMDB() []byte {
+	var buf bytes.Buffer
+
+	// tree: left record is data offset 0, right one is "not found"
+	buf.Write([]byte{0x00, 0x00, 0x11, 0x00, 0x00, 0x01})
+	buf.Write(make([]byte, 16))
+
+	// data: map{"cc": pointer to 6}, "CZ"
+	buf.Write([]byte{0xE1, 0x42, 'c', 'c', 0x20, 0x06, 0x42, 'C', 'Z'})
+
+	buf.Write(mmdbMetadataMarker)
+	buf.Write([]byte{0xE3})
+	buf.Write([]byte{0x4A})
+	buf.WriteString("node_count")
+	buf.Write([]byte{0xC1, 0x01})
+	buf.Write([]byte{0x4B})
+	buf.WriteString("record_size")
+	buf.Write([]byte{0xA1, 24})
+	buf.Write([]byte{0x4A})
+	buf.WriteString("ip_version")
+	buf.Write([]byte{0xA1, 4})
+
+	return buf.Bytes()
+}
+
+func TestMMDBLookup(t *testing.T) {
+	r, err := newMMDB(testMMDB())
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	rec, err := r.lookup(net.ParseIP("10.0.0.1"))
+	if nil != err {
+		t.Fatal(err)
+	}
+	m, ok := rec.(map[string]interface{})
+	if !ok || "CZ" != m["cc"] {
+		t.Fatalf("unexpected record %#v", rec)
+	}
+
+	rec, err = r.lookup(net.ParseIP("192.0.2.1"))
+	if nil != err || nil != rec {
+		t.Fatalf("expected no record, got %#v, %v", rec, err)
+	}
+}
+
+func TestMMDBDecodeInvalid(t *testing.T) {
+	nested := func(levels int) []byte {
+		var b []byte
+		for i := 0; i < levels; i++ {
+			// extended type array of one element
+			b = append(b, 0x01, 0x04)
+		}
+		return append(b, 0x40)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"pointer cycle", []byte{0xE1, 0x41, 'a', 0x20, 0x00}},
+		{"pointer to pointer", []byte{0x20, 0x02, 0x20, 0x00}},
+		{"pointer out of data", []byte{0x20, 0x10}},
+		{"huge array", []byte{0x1F, 0x04, 0xFF, 0xFF, 0xFF}},
+		{"huge map", []byte{0xFF, 0xFF, 0xFF, 0xFF}},
+		{"too deep", nested(mmdbMaxDepth + 2)},
+		{"truncated string", []byte{0x45, 'a'}},
+	}
+
+	for _, test := range tests {
+		if _, _, err := mmdbDecode(test.data, 0); errMMDBInvalid != err {
+			t.Errorf("%s: expected errMMDBInvalid, got %v", test.name, err)
+		}
+	}
+
+	if _, _, err := mmdbDecode(nested(8), 0); nil != err {
+		t.Errorf("nested arrays: %v", err)
+	}
+}
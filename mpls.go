@@ -0,0 +1,210 @@
+package tracelib
+
+import (
+	"time"
+
+	"golang.org/x/net/icmp"
+)
+
+// MPLSLabel is label stack entry quoted in ICMP extension (RFC 4950)
+type MPLSLabel struct {
+	Label int
+	TC    int
+	S     bool
+	TTL   int
+}
+
+// TunnelKind is type of MPLS tunnel depending on ttl-propagate and RFC 4950
+// configuration of routers
+type TunnelKind int
+
+const (
+	// TunnelExplicit has all LSRs visible and quoting labels
+	TunnelExplicit TunnelKind = iota
+	// TunnelImplicit has all LSRs visible but without labels, they are
+	// revealed by quoted IP TTL larger than 1
+	TunnelImplicit
+	// TunnelOpaque hides LSRs, only egress router quotes label with TTL
+	// revealing tunnel length
+	TunnelOpaque
+	// TunnelInvisible hides LSRs completely, it's inferred from jump of
+	// return path length together with RTT jump or from duplicate address
+	// of consecutive hops
+	TunnelInvisible
+)
+
+func (k TunnelKind) String() string {
+	switch k {
+	case TunnelExplicit:
+		return "explicit"
+	case TunnelImplicit:
+		return "implicit"
+	case TunnelOpaque:
+		return "opaque"
+	case TunnelInvisible:
+		return "invisible"
+	}
+	return "invalid"
+}
+
+const (
+	// TunnelRTTJump is minimal RTT increase between hops required to infer
+	// invisible tunnel from return path length jump
+	TunnelRTTJump = 5 * time.Millisecond
+	// TunnelMinHidden is minimal number of extra return path hops inferring
+	// invisible tunnel
+	TunnelMinHidden = 2
+)
+
+// Tunnel is MPLS tunnel inferred from trace
+type Tunnel struct {
+	Kind TunnelKind
+	// FirstHop and LastHop are 1-based numbers of hops revealing tunnel,
+	// for invisible tunnels they are hops before and after hidden part
+	FirstHop int
+	LastHop  int
+	// Hidden is estimated number of LSRs not visible in trace, 0 if all of
+	// them are visible or number is unknown
+	Hidden int
+	// Labels are top labels quoted by hops of tunnel
+	Labels []int
+}
+
+// setQuoted sets quoted TTL and MPLS labels of hop from quoted probe
+func setQuoted(hop *Hop, q *quotedPacket) {
+	hop.QuotedTTL = q.ttl
+
+	hop.MPLS = nil
+	for _, ext := range q.extensions {
+		if stack, ok := ext.(*icmp.MPLSLabelStack); ok {
+			for _, l := range stack.Labels {
+				hop.MPLS = append(hop.MPLS, MPLSLabel{Label: l.Label, TC: l.TC, S: l.S, TTL: l.TTL})
+			}
+		}
+	}
+}
+
+// returnPathLength estimates number of hops of return path from reply TTL
+// assuming one of common initial TTLs
+func returnPathLength(replyTTL int) int {
+	for _, initial := range []int{32, 64, 128, 255} {
+		if replyTTL <= initial {
+			return initial - replyTTL + 1
+		}
+	}
+	return 0
+}
+
+// bestResponder returns responder of hop with most replies, nil if none
+func bestResponder(hop []MHop) *MHop {
+	var best *MHop
+	for j := range hop {
+		h := &hop[j]
+		if nil == h.Addr || h.Total == h.Lost {
+			continue
+		}
+		if nil == best || h.Total-h.Lost > best.Total-best.Lost {
+			best = h
+		}
+	}
+	return best
+}
+
+// InferTunnels infers MPLS tunnels from labels, quoted and reply TTLs and
+// RTTs of responder with most replies of every hop and sets Tunnel of all
+// responders of hops belonging to tunnel; reply TTLs are available only
+// from RunTrace and RunMultiTrace
+func InferTunnels(hops [][]MHop) []Tunnel {
+	var (
+		tunnels []Tunnel
+		open    = -1 // index of explicit or implicit tunnel being extended
+		prev    *MHop
+		prevHop int
+	)
+
+	for i, hop := range hops {
+		h := bestResponder(hop)
+		if nil == h {
+			open = -1
+			continue
+		}
+
+		kind := TunnelKind(-1)
+		switch {
+		case len(h.MPLS) > 0 && h.MPLS[0].TTL <= 1:
+			kind = TunnelExplicit
+		case len(h.MPLS) > 0:
+			kind = TunnelOpaque
+		case h.QuotedTTL > 1:
+			kind = TunnelImplicit
+		}
+
+		switch {
+		case TunnelOpaque == kind:
+			t := Tunnel{Kind: kind, FirstHop: i + 1, LastHop: i + 1, Labels: []int{h.MPLS[0].Label}}
+			// ingress sets label TTL to 255 when TTL isn't propagated
+			if h.MPLS[0].TTL > 128 {
+				t.Hidden = 255 - h.MPLS[0].TTL
+			}
+			tunnels = append(tunnels, t)
+			open = -1
+		case kind < 0:
+			open = -1
+		case open >= 0 && tunnels[open].Kind == kind && tunnels[open].LastHop == i:
+			tunnels[open].LastHop = i + 1
+			if len(h.MPLS) > 0 {
+				tunnels[open].Labels = append(tunnels[open].Labels, h.MPLS[0].Label)
+			}
+		default:
+			t := Tunnel{Kind: kind, FirstHop: i + 1, LastHop: i + 1}
+			if len(h.MPLS) > 0 {
+				t.Labels = []int{h.MPLS[0].Label}
+			}
+			tunnels = append(tunnels, t)
+			open = len(tunnels) - 1
+		}
+
+		if nil != prev && TunnelOpaque != kind && !h.Final {
+			if t := invisibleTunnel(prev, prevHop, h, i+1); nil != t {
+				tunnels = append(tunnels, *t)
+				open = -1
+			}
+		}
+
+		prev = h
+		prevHop = i + 1
+	}
+
+	for k := range tunnels {
+		t := &tunnels[k]
+		for i := t.FirstHop - 1; i < t.LastHop && i < len(hops); i++ {
+			for j := range hops[i] {
+				hops[i][j].Tunnel = t
+			}
+		}
+	}
+
+	return tunnels
+}
+
+// invisibleTunnel checks pair of responding hops for signs of hidden LSRs
+// between them
+func invisibleTunnel(a *MHop, aHop int, b *MHop, bHop int) *Tunnel {
+	hidden := 0
+	if a.ReplyTTL > 0 && b.ReplyTTL > 0 {
+		hidden = returnPathLength(b.ReplyTTL) - returnPathLength(a.ReplyTTL) - (bHop - aHop)
+	}
+
+	rttJump := b.MinRTT-a.MinRTT >= TunnelRTTJump
+	// egress router of tunnel with penultimate hop popping appears twice
+	duplicate := bHop == aHop+1 && a.Addr.String() == b.Addr.String()
+
+	if !duplicate && (hidden < TunnelMinHidden || !rttJump) {
+		return nil
+	}
+	if hidden < 0 {
+		hidden = 0
+	}
+
+	return &Tunnel{Kind: TunnelInvisible, FirstHop: aHop, LastHop: bHop, Hidden: hidden}
+}
@@ -0,0 +1,359 @@
+package tracelib
+
+import (
+	"encoding/binary"
+	"net"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// NATProbe is protocol of probes used by DetectNAT
+type NATProbe int
+
+const (
+	// NATProbeICMP uses ICMP echo requests, translation is visible as
+	// changed source address, identifier or checksum
+	NATProbeICMP NATProbe = iota
+	// NATProbeUDP uses UDP datagrams, translation is visible as changed
+	// source address, source port or checksum
+	NATProbeUDP
+)
+
+// NATBasePort is destination port of UDP probe with TTL 1, every next TTL
+// uses next port
+const NATBasePort = 33434
+
+// NATHop is comparison of probe headers quoted by hop with sent ones
+type NATHop struct {
+	Addr    net.Addr
+	RTT     time.Duration
+	Final   bool
+	Timeout bool
+	// QuotedSrc is source address of probe as seen by hop
+	QuotedSrc  net.IP
+	SrcChanged bool
+	// IDChanged means ICMP identifier or UDP source port was rewritten
+	IDChanged       bool
+	ChecksumChanged bool
+}
+
+// Translated returns true if hop quoted probe different from sent one
+func (h NATHop) Translated() bool {
+	return h.SrcChanged || h.IDChanged || h.ChecksumChanged
+}
+
+// NATResult is result of DetectNAT
+type NATResult struct {
+	Probe NATProbe
+	// Source is address probes were sent from
+	Source net.IP
+	// Hops are indexed by TTL-1
+	Hops []NATHop
+	// FirstTranslated is 1-based number of first hop quoting translated
+	// probe, NAT is between it and previous hop; 0 if none was found
+	FirstTranslated int
+}
+
+// natProbe is sent probe with fields expected in quoted headers
+type natProbe struct {
+	id       uint16
+	seq      uint16
+	checksum uint16
+}
+
+// natPayload is payload of UDP probes
+var natPayload = []byte("tracelib")
+
+// natTracer holds sockets and addresses of DetectNAT
+type natTracer struct {
+	t     *trace
+	probe NATProbe
+	src   net.IP
+	dest  net.IP
+	udp   net.PacketConn
+	udp4  *ipv4.PacketConn
+	udp6  *ipv6.PacketConn
+}
+
+// DetectNAT traces host like RunTrace comparing source address, identifier
+// and checksum quoted in every ICMP error with sent ones; NAT which doesn't
+// restore all quoted fields of errors it translates back is revealed by
+// first hop after it
+func DetectNAT(host string, source string, source6 string, maxrtt time.Duration, maxttl int, probe NATProbe) (*NATResult, error) {
+	t, err := newTrace(host, source, source6, maxrtt, maxttl, nil)
+	if nil != err {
+		return nil, err
+	}
+	defer t.Close()
+
+	n := natTracer{t: t, probe: probe, dest: t.dest.(*net.IPAddr).IP}
+
+	network := "udp4"
+	if nil != t.ipv6conn {
+		network = "udp6"
+		source = source6
+	}
+
+	n.src, err = localIP(n.dest, source)
+	if nil != err {
+		return nil, err
+	}
+
+	if NATProbeUDP == probe {
+		n.udp, err = net.ListenPacket(network, net.JoinHostPort(source, "0"))
+		if nil != err {
+			return nil, err
+		}
+		defer n.udp.Close()
+		if nil != t.ipv6conn {
+			n.udp6 = ipv6.NewPacketConn(n.udp)
+		} else {
+			n.udp4 = ipv4.NewPacketConn(n.udp)
+		}
+	}
+
+	result := &NATResult{Probe: probe, Source: n.src}
+
+	timeouts := 0
+	for ttl := 1; ttl <= maxttl; ttl++ {
+		hop, err := n.step(ttl)
+		if nil != err {
+			return nil, err
+		}
+
+		result.Hops = append(result.Hops, hop)
+		if 0 == result.FirstTranslated && hop.Translated() {
+			result.FirstTranslated = ttl
+		}
+
+		if hop.Final {
+			break
+		}
+		if hop.Timeout {
+			timeouts++
+		} else {
+			timeouts = 0
+		}
+		if timeouts == MaxTimeouts {
+			break
+		}
+	}
+
+	return result, nil
+}
+
+// localIP returns source address used for packets to dest
+func localIP(dest net.IP, source string) (net.IP, error) {
+	if ip := net.ParseIP(source); nil != ip && !ip.IsUnspecified() {
+		return ip, nil
+	}
+
+	// connecting UDP socket only selects route, nothing is sent
+	c, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: dest, Port: NATBasePort})
+	if nil != err {
+		return nil, err
+	}
+	defer c.Close()
+
+	return c.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+// step sends one probe with specified TTL and compares quoted reply
+func (n *natTracer) step(ttl int) (NATHop, error) {
+	var (
+		sent natProbe
+		err  error
+	)
+
+	sendOn := time.Now()
+	if NATProbeUDP == n.probe {
+		sent, err = n.sendUDP(ttl)
+	} else {
+		sent, err = n.sendICMP(ttl)
+	}
+	if nil != err {
+		return NATHop{}, err
+	}
+
+	return n.receive(sent, sendOn)
+}
+
+// sendICMP sends echo request with sequence number ttl
+func (n *natTracer) sendICMP(ttl int) (natProbe, error) {
+	var (
+		msg icmp.Message
+		psh []byte
+		wcm ipv6.ControlMessage
+	)
+
+	body := &icmp.Echo{ID: n.t.id, Seq: ttl}
+	if nil != n.t.ipv6conn {
+		msg = icmp.Message{Type: ipv6.ICMPTypeEchoRequest, Code: 0, Body: body}
+		// kernel computes the same checksum, we need to know it in advance
+		psh = icmp.IPv6PseudoHeader(n.src, n.dest)
+	} else {
+		msg = icmp.Message{Type: ipv4.ICMPTypeEcho, Code: 0, Body: body}
+	}
+
+	b, err := msg.Marshal(psh)
+	if nil != err {
+		return natProbe{}, err
+	}
+	sent := natProbe{id: uint16(n.t.id), seq: uint16(ttl), checksum: binary.BigEndian.Uint16(b[2:4])}
+
+	if nil != n.t.ipv4conn {
+		if err := n.t.ipv4conn.SetTTL(ttl); nil != err {
+			return sent, err
+		}
+		_, err = n.t.conn.WriteTo(b, n.t.dest)
+	} else {
+		wcm.HopLimit = ttl
+		_, err = n.t.ipv6conn.WriteTo(b, &wcm, n.t.dest)
+	}
+
+	return sent, err
+}
+
+// sendUDP sends datagram to port NATBasePort+ttl-1
+func (n *natTracer) sendUDP(ttl int) (natProbe, error) {
+	var err error
+	if nil != n.udp4 {
+		err = n.udp4.SetTTL(ttl)
+	} else {
+		err = n.udp6.SetHopLimit(ttl)
+	}
+	if nil != err {
+		return natProbe{}, err
+	}
+
+	port := NATBasePort + ttl - 1
+	lport := n.udp.LocalAddr().(*net.UDPAddr).Port
+
+	// UDP header and payload exactly as kernel sends them
+	b := make([]byte, 8+len(natPayload))
+	binary.BigEndian.PutUint16(b[0:2], uint16(lport))
+	binary.BigEndian.PutUint16(b[2:4], uint16(port))
+	binary.BigEndian.PutUint16(b[4:6], uint16(len(b)))
+	copy(b[8:], natPayload)
+
+	sent := natProbe{id: uint16(lport), seq: uint16(port), checksum: pseudoChecksum(n.src, n.dest, 17, b)}
+	if 0 == sent.checksum {
+		sent.checksum = 0xffff
+	}
+
+	_, err = n.udp.WriteTo(natPayload, &net.UDPAddr{IP: n.dest, Port: port})
+	return sent, err
+}
+
+// receive waits for reply to sent probe, for ICMP errors quoted source
+// address, identifier and checksum are compared with sent ones
+func (n *natTracer) receive(sent natProbe, sendOn time.Time) (NATHop, error) {
+	var hop NATHop
+
+	if err := n.t.conn.SetReadDeadline(sendOn.Add(n.t.maxrtt)); nil != err {
+		return hop, err
+	}
+
+	proto := ProtocolICMP
+	if nil != n.t.ipv6conn {
+		proto = ProtocolICMP6
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		readLen, addr, err := n.t.conn.ReadFrom(buf)
+		if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+			hop.Timeout = true
+			return hop, nil
+		}
+		if nil != err {
+			return hop, err
+		}
+
+		msg, err := icmp.ParseMessage(proto, buf[:readLen])
+		if nil != err {
+			continue
+		}
+
+		if body, ok := msg.Body.(*icmp.Echo); ok {
+			if NATProbeICMP != n.probe || (ipv4.ICMPTypeEchoReply != msg.Type && ipv6.ICMPTypeEchoReply != msg.Type) {
+				continue
+			}
+			if n.t.id != body.ID || int(sent.seq) != body.Seq {
+				continue
+			}
+			hop.Addr = addr
+			hop.RTT = time.Since(sendOn)
+			hop.Final = true
+			return hop, nil
+		}
+
+		q, ok := quotedFrom(msg.Body)
+		if !ok {
+			continue
+		}
+		id, seq, ok := n.quoted(&q)
+		if !ok || seq != sent.seq {
+			continue
+		}
+		_, final := msg.Body.(*icmp.DstUnreach)
+
+		hop.Addr = addr
+		hop.RTT = time.Since(sendOn)
+		hop.Final = final
+		hop.QuotedSrc = q.src
+		hop.SrcChanged = !q.src.Equal(n.src)
+		hop.IDChanged = id != sent.id
+		// checksum of probe delivered locally is left incomplete by offload
+		hop.ChecksumChanged = q.checksum != sent.checksum && !addrIP(addr).Equal(n.src)
+		return hop, nil
+	}
+}
+
+// quoted matches probe quoted in ICMP error with sent probe type and
+// destination, seq is ICMP sequence number or UDP destination port, id is
+// ICMP identifier or UDP source port
+func (n *natTracer) quoted(q *quotedPacket) (id, seq uint16, ok bool) {
+	if !q.dst.Equal(n.dest) {
+		return 0, 0, false
+	}
+
+	switch {
+	case NATProbeUDP == n.probe && protocolUDP == q.proto:
+		return uint16(q.srcPort), uint16(q.dstPort), true
+	case NATProbeICMP == n.probe && q.isEcho():
+		return uint16(q.id), uint16(q.seq), true
+	}
+
+	return 0, 0, false
+}
+
+// pseudoChecksum returns internet checksum of b with IPv4 or IPv6 pseudo
+// header
+func pseudoChecksum(src net.IP, dst net.IP, proto int, b []byte) uint16 {
+	var psh []byte
+	if s4, d4 := src.To4(), dst.To4(); nil != s4 && nil != d4 {
+		psh = append(append(psh, s4...), d4...)
+		psh = append(psh, 0, byte(proto), byte(len(b)>>8), byte(len(b)))
+	} else {
+		psh = append(append(psh, src.To16()...), dst.To16()...)
+		psh = append(psh, byte(len(b)>>24), byte(len(b)>>16), byte(len(b)>>8), byte(len(b)), 0, 0, 0, byte(proto))
+	}
+
+	var sum uint32
+	data := append(psh, b...)
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(data[i])<<8 | uint32(data[i+1])
+	}
+	if 1 == len(data)%2 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	for 0 != sum>>16 {
+		sum = sum&0xffff + sum>>16
+	}
+
+	return ^uint16(sum)
+}
@@ -0,0 +1,128 @@
+package tracelib
+
+import (
+	"net"
+	"time"
+)
+
+// Options holds optional settings of Run*Opts functions, nil means defaults
+type Options struct {
+	// StopOnLoop stops probing as soon as forwarding loop is confirmed
+	StopOnLoop bool
+	// LoopRepeats is number of cycles confirming loop, 2 if 0
+	LoopRepeats int
+	// IPOption sends additional echo request with Record Route or
+	// Timestamp option to IPv4 destination after trace
+	IPOption IPOption
+	// Family is address family policy of destination selection
+	Family AddrFamily
+	// SampleAddrs limits RunAllAddrsTrace to random sample of addresses,
+	// 0 means all
+	SampleAddrs int
+	// TraceWorkers is number of concurrent traces of RunAllAddrsTrace,
+	// DefaultTraceWorkers if 0
+	TraceWorkers int
+	// Resolver is used for hostname targets, net.DefaultResolver if nil
+	Resolver Resolver
+}
+
+// TraceResult is result of trace with analysis attached
+type TraceResult struct {
+	// Hops contains all rounds of every hop
+	Hops [][]Hop
+	// Loop is forwarding loop detected in trace, nil if none
+	Loop *Loop
+	// Recorded is result of IP option probe, nil if not requested,
+	// destination is IPv6 or probe failed with RecordedErr
+	Recorded    *RecordedRoute
+	RecordedErr error
+	// Error is error of this host in RunMPTraceOpts (e.g. resolution
+	// failure), Hops are nil then
+	Error error
+	// Dest is resolved destination address
+	Dest net.Addr
+	// Reached is true if destination replied to any probe
+	Reached bool
+	// DestRTT is lowest RTT of destination replies
+	DestRTT time.Duration
+	// ProbeErrors is number of probes failed with error (e.g. sending
+	// failure) and ProbeError is first of these errors
+	ProbeErrors int
+	ProbeError  error
+	// Start and Duration are time trace started and how long it took,
+	// shared by all hosts of RunMPTraceOpts
+	Start    time.Time
+	Duration time.Duration
+}
+
+func (opts *Options) stopOnLoop() bool {
+	return nil != opts && opts.StopOnLoop
+}
+
+func (opts *Options) family() AddrFamily {
+	if nil == opts {
+		return FamilyPreferV4
+	}
+	return opts.Family
+}
+
+func (opts *Options) resolver() Resolver {
+	if nil == opts {
+		return nil
+	}
+	return opts.Resolver
+}
+
+func (opts *Options) traceWorkers() int {
+	if nil == opts || opts.TraceWorkers < 1 {
+		return DefaultTraceWorkers
+	}
+	return opts.TraceWorkers
+}
+
+func (opts *Options) loopRepeats() int {
+	if nil == opts || opts.LoopRepeats < 2 {
+		return 2
+	}
+	return opts.LoopRepeats
+}
+
+// newTraceResult creates result of trace started at start with summary of
+// hops
+func newTraceResult(hops [][]Hop, loop *Loop, start time.Time) *TraceResult {
+	r := &TraceResult{Hops: hops, Loop: loop, Start: start, Duration: time.Since(start)}
+
+	for _, hop := range hops {
+		for _, h := range hop {
+			if nil != h.Error {
+				r.ProbeErrors++
+				if nil == r.ProbeError {
+					r.ProbeError = h.Error
+				}
+			}
+			if h.Final {
+				r.Reached = true
+				if 0 == r.DestRTT || h.RTT < r.DestRTT {
+					r.DestRTT = h.RTT
+				}
+			}
+		}
+	}
+
+	return r
+}
+
+// probeIPOption attaches recorded route of traced IPv4 destination to result
+// if requested by opts, failure of probe is stored in RecordedErr
+func (r *TraceResult) probeIPOption(source string, maxrtt time.Duration, opts *Options) {
+	if nil == opts || OptionNone == opts.IPOption || nil == r.Dest {
+		return
+	}
+
+	dest := addrIP(r.Dest)
+	if nil == dest.To4() {
+		return
+	}
+
+	r.Recorded, r.RecordedErr = probeIPOption(dest.String(), source, maxrtt, opts.IPOption, opts)
+}
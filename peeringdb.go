@@ -0,0 +1,197 @@
+package tracelib
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+// IXPInfo describes IXP peering LAN hop address belongs to
+type IXPInfo struct {
+	Name    string
+	City    string
+	Country string
+	// MemberASN and MemberName identify network using this address on
+	// peering LAN, MemberASN is 0 if address isn't found in netixlan
+	MemberASN  int64
+	MemberName string
+}
+
+// pdbDump is subset of PeeringDB JSON export we are interested in
+type pdbDump struct {
+	IX struct {
+		Data []struct {
+			ID      int    `json:"id"`
+			Name    string `json:"name"`
+			City    string `json:"city"`
+			Country string `json:"country"`
+		} `json:"data"`
+	} `json:"ix"`
+	IXLan struct {
+		Data []struct {
+			ID   int `json:"id"`
+			IXID int `json:"ix_id"`
+		} `json:"data"`
+	} `json:"ixlan"`
+	IXPfx struct {
+		Data []struct {
+			IXLanID int    `json:"ixlan_id"`
+			Prefix  string `json:"prefix"`
+		} `json:"data"`
+	} `json:"ixpfx"`
+	NetIXLan struct {
+		Data []struct {
+			NetID   int    `json:"net_id"`
+			IXLanID int    `json:"ixlan_id"`
+			ASN     int64  `json:"asn"`
+			Name    string `json:"name"`
+			IPAddr4 string `json:"ipaddr4"`
+			IPAddr6 string `json:"ipaddr6"`
+		} `json:"data"`
+	} `json:"netixlan"`
+	Net struct {
+		Data []struct {
+			ID   int    `json:"id"`
+			ASN  int64  `json:"asn"`
+			Name string `json:"name"`
+		} `json:"data"`
+	} `json:"net"`
+}
+
+// ixPrefix is peering LAN prefix with its IXP
+type ixPrefix struct {
+	net *net.IPNet
+	ix  IXPInfo
+}
+
+// PeeringDB holds IXP peering LANs and their members loaded from
+// offline PeeringDB JSON export
+type PeeringDB struct {
+	prefixes []ixPrefix
+	members  map[string]IXPInfo
+}
+
+// LoadPeeringDB parses PeeringDB JSON export (ix, ixlan, ixpfx, netixlan
+// and optionally net objects)
+func LoadPeeringDB(r io.Reader) (*PeeringDB, error) {
+	var dump pdbDump
+	if err := json.NewDecoder(r).Decode(&dump); nil != err {
+		return nil, err
+	}
+
+	ixs := make(map[int]IXPInfo, len(dump.IX.Data))
+	for _, ix := range dump.IX.Data {
+		ixs[ix.ID] = IXPInfo{Name: ix.Name, City: ix.City, Country: ix.Country}
+	}
+
+	lans := make(map[int]IXPInfo, len(dump.IXLan.Data))
+	for _, lan := range dump.IXLan.Data {
+		lans[lan.ID] = ixs[lan.IXID]
+	}
+
+	nets := make(map[int]string, len(dump.Net.Data))
+	for _, n := range dump.Net.Data {
+		nets[n.ID] = n.Name
+	}
+
+	db := &PeeringDB{members: make(map[string]IXPInfo, len(dump.NetIXLan.Data))}
+
+	for _, pfx := range dump.IXPfx.Data {
+		_, n, err := net.ParseCIDR(strings.TrimSpace(pfx.Prefix))
+		if nil != err {
+			continue
+		}
+		db.prefixes = append(db.prefixes, ixPrefix{net: n, ix: lans[pfx.IXLanID]})
+	}
+
+	for _, m := range dump.NetIXLan.Data {
+		info := lans[m.IXLanID]
+		info.MemberASN = m.ASN
+		info.MemberName = m.Name
+		if name, ok := nets[m.NetID]; ok && "" != name {
+			info.MemberName = name
+		}
+
+		for _, addr := range []string{m.IPAddr4, m.IPAddr6} {
+			if ip := net.ParseIP(addr); nil != ip {
+				db.members[ip.String()] = info
+			}
+		}
+	}
+
+	return db, nil
+}
+
+// LoadPeeringDBFile loads PeeringDB JSON export from file
+func LoadPeeringDBFile(filename string) (*PeeringDB, error) {
+	f, err := os.Open(filename)
+	if nil != err {
+		return nil, err
+	}
+	defer f.Close()
+
+	return LoadPeeringDB(f)
+}
+
+// Lookup returns IXP of ip, nil if ip isn't from known peering LAN
+func (db *PeeringDB) Lookup(ip net.IP) *IXPInfo {
+	if nil == ip {
+		return nil
+	}
+
+	if info, ok := db.members[ip.String()]; ok {
+		return &info
+	}
+
+	for _, p := range db.prefixes {
+		if p.net.Contains(ip) {
+			info := p.ix
+			return &info
+		}
+	}
+
+	return nil
+}
+
+// LookupAS implements ASResolver returning member ASN of peering LAN address
+func (db *PeeringDB) LookupAS(ip string) (int64, error) {
+	if info := db.Lookup(net.ParseIP(ip)); nil != info && info.MemberASN > 0 {
+		return info.MemberASN, nil
+	}
+	return -1, ErrASNotFound
+}
+
+// AddToClassifier adds all peering LAN prefixes to c
+func (db *PeeringDB) AddToClassifier(c *Classifier) {
+	c.mutex.Lock()
+	for _, p := range db.prefixes {
+		c.ixp = append(c.ixp, p.net)
+	}
+	c.mutex.Unlock()
+}
+
+// EnrichHops sets IXP of all hops from peering LANs
+func (db *PeeringDB) EnrichHops(hops [][]Hop) {
+	for i := range hops {
+		for j := range hops[i] {
+			hops[i][j].IXP = db.Lookup(addrIP(hops[i][j].Addr))
+			if nil != hops[i][j].IXP {
+				hops[i][j].Class = ClassIXP
+			}
+		}
+	}
+}
+
+// EnrichMulti sets IXP of all aggregated hops from peering LANs
+func (db *PeeringDB) EnrichMulti(hops [][]MHop) {
+	for i := range hops {
+		for j := range hops[i] {
+			hops[i][j].IXP = db.Lookup(addrIP(hops[i][j].Addr))
+			if nil != hops[i][j].IXP {
+				hops[i][j].Class = ClassIXP
+			}
+		}
+	}
+}
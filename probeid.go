@@ -0,0 +1,102 @@
+package tracelib
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+)
+
+// probeSeqs is number of distinct sequence numbers (and identifiers) of
+// ICMP echo
+const probeSeqs = 1 << 16
+
+// ErrProbeCapacity is returned by parallel tracers if probes of all hosts
+// can't be identified by ICMP identifiers and sequence numbers
+var ErrProbeCapacity = errors.New("Too many probes for ICMP identifier space")
+
+// probeSpace maps probes (host, hop, round) of parallel tracers to ICMP
+// identifier and sequence number; probe number hop + maxttl*round of host
+// is split into as many identifiers as needed (idsPerHost) of 65536
+// sequence numbers each, identifiers of hosts follow each other from start
+// wrapping around 65535
+type probeSpace struct {
+	start      uint16
+	hosts      int
+	maxttl     int
+	rounds     int
+	idsPerHost int
+}
+
+// newProbeSpace validates parameters of parallel trace and creates mapping
+// of its probes
+func newProbeSpace(startID int, hosts int, maxttl int, rounds int) (*probeSpace, error) {
+	if startID < 0 || startID >= probeSeqs {
+		return nil, fmt.Errorf("ICMP identifier %d is out of range 0-65535", startID)
+	}
+	if maxttl < 1 || maxttl > 255 {
+		return nil, fmt.Errorf("Max TTL %d is out of range 1-255", maxttl)
+	}
+	if rounds < 1 {
+		return nil, errors.New("Number of rounds must be positive")
+	}
+
+	s := &probeSpace{start: uint16(startID), hosts: hosts, maxttl: maxttl, rounds: rounds}
+	s.idsPerHost = (maxttl*rounds + probeSeqs - 1) / probeSeqs
+	if hosts*s.idsPerHost > probeSeqs {
+		return nil, fmt.Errorf("%w: %d hosts need %d identifiers each", ErrProbeCapacity, hosts, s.idsPerHost)
+	}
+
+	return s, nil
+}
+
+// encode returns ICMP identifier and sequence number of probe
+func (s *probeSpace) encode(host int, hop int, round int) (int, int) {
+	p := hop + s.maxttl*round
+	k := host*s.idsPerHost + p/probeSeqs
+	return int(s.start + uint16(k)), p % probeSeqs
+}
+
+// decode returns host, hop and round of probe, ok is false if identifier
+// and sequence number don't belong to this trace
+func (s *probeSpace) decode(id int, seq int) (host int, hop int, round int, ok bool) {
+	k := int(uint16(id) - s.start)
+	if k >= s.hosts*s.idsPerHost || seq < 0 || seq >= probeSeqs {
+		return 0, 0, 0, false
+	}
+
+	p := (k%s.idsPerHost)*probeSeqs + seq
+	if p >= s.maxttl*s.rounds {
+		return 0, 0, 0, false
+	}
+
+	return k / s.idsPerHost, p % s.maxttl, p / s.maxttl, true
+}
+
+// traceIDs are ICMP identifiers of open sequential traces, so concurrent
+// traces of process (RunDualTrace, RunAllAddrsTrace) never share one
+var (
+	traceIDs      = map[int]bool{}
+	traceIDsMutex sync.Mutex
+)
+
+// acquireTraceID returns random identifier not used by other open trace
+func acquireTraceID() int {
+	traceIDsMutex.Lock()
+	defer traceIDsMutex.Unlock()
+
+	for {
+		id := rand.Int() % 0x7fff
+		if !traceIDs[id] {
+			traceIDs[id] = true
+			return id
+		}
+	}
+}
+
+// releaseTraceID returns identifier of closed trace
+func releaseTraceID(id int) {
+	traceIDsMutex.Lock()
+	delete(traceIDs, id)
+	traceIDsMutex.Unlock()
+}
@@ -0,0 +1,99 @@
+package tracelib
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestProbeSpaceRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		startID int
+		hosts   int
+		maxttl  int
+		rounds  int
+		ids     int // expected identifiers per host
+	}{
+		{"single id", 1000, 3, 30, 10, 1},
+		{"start near wraparound", 65534, 4, 64, 5, 1},
+		{"last id", 65535, 2, 32, 3, 1},
+		{"several ids per host", 100, 3, 255, 600, 3},
+		{"several ids across wraparound", 65533, 2, 200, 700, 3},
+	}
+
+	for _, test := range tests {
+		s, err := newProbeSpace(test.startID, test.hosts, test.maxttl, test.rounds)
+		if nil != err {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if test.ids != s.idsPerHost {
+			t.Errorf("%s: expected %d identifiers per host, got %d", test.name, test.ids, s.idsPerHost)
+		}
+
+		seen := make(map[[2]int]bool, test.hosts*test.maxttl*test.rounds)
+		for host := 0; host < test.hosts; host++ {
+			for round := 0; round < test.rounds; round++ {
+				for hop := 0; hop < test.maxttl; hop++ {
+					id, seq := s.encode(host, hop, round)
+					if id < 0 || id >= probeSeqs || seq < 0 || seq >= probeSeqs {
+						t.Fatalf("%s: probe %d/%d/%d encoded out of range %d/%d", test.name, host, hop, round, id, seq)
+					}
+					if seen[[2]int{id, seq}] {
+						t.Fatalf("%s: probe %d/%d/%d shares %d/%d", test.name, host, hop, round, id, seq)
+					}
+					seen[[2]int{id, seq}] = true
+
+					h, p, r, ok := s.decode(id, seq)
+					if !ok || h != host || p != hop || r != round {
+						t.Fatalf("%s: %d/%d/%d decoded as %d/%d/%d (%v)", test.name, host, hop, round, h, p, r, ok)
+					}
+				}
+			}
+		}
+
+		// identifiers just outside of trace must be rejected
+		for _, id := range []int{test.startID - 1, test.startID + test.hosts*test.ids} {
+			if _, _, _, ok := s.decode(int(uint16(id)), 0); ok {
+				t.Errorf("%s: foreign identifier %d decoded", test.name, uint16(id))
+			}
+		}
+	}
+}
+
+func TestProbeSpaceUnusedSeq(t *testing.T) {
+	s, err := newProbeSpace(10, 1, 255, 300)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	// 76500 probes, second identifier is used only partially
+	if _, _, _, ok := s.decode(11, 255*300-probeSeqs); ok {
+		t.Error("sequence number beyond last probe decoded")
+	}
+}
+
+func TestProbeSpaceInvalid(t *testing.T) {
+	tests := []struct {
+		name                           string
+		startID, hosts, maxttl, rounds int
+		capacity                       bool
+	}{
+		{"negative id", -1, 1, 30, 1, false},
+		{"too large id", 65536, 1, 30, 1, false},
+		{"zero ttl", 0, 1, 0, 1, false},
+		{"too large ttl", 0, 1, 256, 1, false},
+		{"zero rounds", 0, 1, 30, 0, false},
+		{"too many hosts", 0, 40000, 255, 300, true},
+	}
+
+	for _, test := range tests {
+		_, err := newProbeSpace(test.startID, test.hosts, test.maxttl, test.rounds)
+		if nil == err {
+			t.Errorf("%s: expected error", test.name)
+			continue
+		}
+		if test.capacity != errors.Is(err, ErrProbeCapacity) {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+	}
+}
@@ -1,7 +1,6 @@
 package tracelib
 
 import (
-	"encoding/binary"
 	"errors"
 	"net"
 	"sync"
@@ -15,8 +14,36 @@ import (
 // expremental implentation of much faster traceroute
 // by sending all packets not one after another but at once
 
-// RunPTrace preforms traceroute to specified host by sending all packets at once
+// RunPTrace preforms traceroute to specified host by sending all packets at once;
+// if rounds*maxttl exceeds 65536 probes use identifiers icmpID+1, icmpID+2, ... too,
+// so concurrent traces must use icmpID values far enough apart not to decode each other's replies
 func RunPTrace(host string, source string, source6 string, maxrtt time.Duration, maxttl int, DNScache *LookupCache, rounds int, icmpID int, delay time.Duration) ([][]Hop, error) {
+	hops, _, err := runPTrace(host, source, source6, maxrtt, maxttl, DNScache, rounds, icmpID, delay, nil)
+	return hops, err
+}
+
+// RunPTraceOpts preforms RunPTrace with optional settings and returns result
+// with analysis attached
+func RunPTraceOpts(host string, source string, source6 string, maxrtt time.Duration, maxttl int, DNScache *LookupCache, rounds int, icmpID int, delay time.Duration, opts *Options) (*TraceResult, error) {
+	start := time.Now()
+	hops, dest, err := runPTrace(host, source, source6, maxrtt, maxttl, DNScache, rounds, icmpID, delay, opts)
+	if nil != err {
+		return nil, err
+	}
+
+	result := newTraceResult(hops, DetectMultiLoop(hops, opts.loopRepeats()), start)
+	result.Dest = dest
+	result.probeIPOption(source, maxrtt, opts)
+
+	return result, nil
+}
+
+func runPTrace(host string, source string, source6 string, maxrtt time.Duration, maxttl int, DNScache *LookupCache, rounds int, icmpID int, delay time.Duration, opts *Options) ([][]Hop, net.Addr, error) {
+
+	space, err := newProbeSpace(icmpID, 1, maxttl, rounds)
+	if nil != err {
+		return nil, nil, err
+	}
 
 	hops := make([][]Hop, maxttl)
 	sendOn := make([][]time.Time, maxttl)
@@ -32,39 +59,11 @@ func RunPTrace(host string, source string, source6 string, maxrtt time.Duration,
 		conn     net.PacketConn
 		ipv4conn *ipv4.PacketConn
 		ipv6conn *ipv6.PacketConn
-		dest     net.Addr
 	)
 
-	var err error
-	isIPv6 := false
-
-	addrList, err := net.LookupIP(host)
+	dest, isIPv6, err := resolveDest(host, opts.family(), opts.resolver())
 	if nil != err {
-		return nil, err
-	}
-
-	for _, addr := range addrList {
-		if addr.To4() != nil {
-			dest, err = net.ResolveIPAddr("ip4:icmp", addr.String())
-			break
-		}
-	}
-
-	if nil == dest {
-		for _, addr := range addrList {
-			if addr.To16() != nil {
-				isIPv6 = true
-				dest, err = net.ResolveIPAddr("ip6:58", addr.String())
-				break
-			}
-		}
-	}
-	if nil == dest {
-		return nil, errors.New("Unable to resolve destination host")
-	}
-
-	if nil != err {
-		return nil, err
+		return nil, nil, err
 	}
 
 	if !isIPv6 {
@@ -73,7 +72,7 @@ func RunPTrace(host string, source string, source6 string, maxrtt time.Duration,
 		conn, err = net.ListenPacket("ip6:58", source6)
 	}
 	if nil != err {
-		return nil, err
+		return nil, nil, err
 	}
 	defer conn.Close()
 
@@ -81,7 +80,7 @@ func RunPTrace(host string, source string, source6 string, maxrtt time.Duration,
 		ipv6conn = ipv6.NewPacketConn(conn)
 		defer ipv6conn.Close()
 		if err := ipv6conn.SetControlMessage(ipv6.FlagHopLimit|ipv6.FlagSrc|ipv6.FlagDst|ipv6.FlagInterface, true); err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		var f ipv6.ICMPFilter
 		f.SetAll(true)
@@ -89,7 +88,7 @@ func RunPTrace(host string, source string, source6 string, maxrtt time.Duration,
 		f.Accept(ipv6.ICMPTypeEchoReply)
 		f.Accept(ipv6.ICMPTypeDestinationUnreachable)
 		if err := ipv6conn.SetICMPFilter(&f); err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 	} else {
 		ipv4conn = ipv4.NewPacketConn(conn)
@@ -120,10 +119,11 @@ func RunPTrace(host string, source string, source6 string, maxrtt time.Duration,
 
 				var msg icmp.Message
 
+				id, seq := space.encode(0, hop, r)
 				if isIPv6 {
-					msg = icmp.Message{Type: ipv6.ICMPTypeEchoRequest, Code: 0, Body: &icmp.Echo{ID: icmpID, Seq: hop + (maxttl * r)}}
+					msg = icmp.Message{Type: ipv6.ICMPTypeEchoRequest, Code: 0, Body: &icmp.Echo{ID: id, Seq: seq}}
 				} else {
-					msg = icmp.Message{Type: ipv4.ICMPTypeEcho, Code: 0, Body: &icmp.Echo{ID: icmpID, Seq: hop + (maxttl * r)}}
+					msg = icmp.Message{Type: ipv4.ICMPTypeEcho, Code: 0, Body: &icmp.Echo{ID: id, Seq: seq}}
 				}
 				netmsg, err := msg.Marshal(nil)
 				if nil != err {
@@ -172,130 +172,135 @@ func RunPTrace(host string, source string, source6 string, maxrtt time.Duration,
 		}
 
 		switch result.Type {
-		case ipv4.ICMPTypeEchoReply:
+		case ipv4.ICMPTypeEchoReply, ipv6.ICMPTypeEchoReply:
 			if rply, ok := result.Body.(*icmp.Echo); ok {
-
-				if icmpID != rply.ID {
-					continue
-				}
-				if maxSeq < rply.Seq {
+				_, hop, r, ok := space.decode(rply.ID, rply.Seq)
+				if !ok {
 					continue
 				}
 
-				hops[rply.Seq%maxttl][rply.Seq/maxttl].Addr = addr
-				hops[rply.Seq%maxttl][rply.Seq/maxttl].RTT = time.Since(sendOn[rply.Seq%maxttl][rply.Seq/maxttl])
-				hops[rply.Seq%maxttl][rply.Seq/maxttl].Final = true
-				hops[rply.Seq%maxttl][rply.Seq/maxttl].Timeout = false
-			}
-		case ipv4.ICMPTypeTimeExceeded:
-			if rply, ok := result.Body.(*icmp.TimeExceeded); ok {
-				if len(rply.Data) > 26 {
-					if uint16(icmpID) != binary.BigEndian.Uint16(rply.Data[24:26]) {
-						continue
-					}
-
-					seq := int(binary.BigEndian.Uint16(rply.Data[26:28]))
-					if maxSeq < seq {
-						continue
-					}
-
-					hops[seq%maxttl][seq/maxttl].Addr = addr
-					hops[seq%maxttl][seq/maxttl].RTT = time.Since(sendOn[seq%maxttl][seq/maxttl])
-					hops[seq%maxttl][seq/maxttl].Timeout = false
-				}
-			}
-		case ipv6.ICMPTypeTimeExceeded:
-			if rply, ok := result.Body.(*icmp.TimeExceeded); ok {
-				if len(rply.Data) > 46 {
-					if uint16(icmpID) != binary.BigEndian.Uint16(rply.Data[44:46]) {
-						continue
-					}
-
-					seq := int(binary.BigEndian.Uint16(rply.Data[26:28]))
-					if maxSeq < seq {
-						continue
-					}
-
-					hops[seq%maxttl][seq/maxttl].Addr = addr
-					hops[seq%maxttl][seq/maxttl].RTT = time.Since(sendOn[seq%maxttl][seq/maxttl])
-					hops[seq%maxttl][seq/maxttl].Timeout = false
-				}
+				hops[hop][r].Addr = addr
+				hops[hop][r].RTT = time.Since(sendOn[hop][r])
+				hops[hop][r].Final = true
+				hops[hop][r].Timeout = false
 			}
-		case ipv6.ICMPTypeEchoReply:
-			if rply, ok := result.Body.(*icmp.Echo); ok {
-				if icmpID != rply.ID {
-					continue
-				}
-				if maxSeq < rply.Seq {
-					continue
-				}
-
-				hops[rply.Seq%maxttl][rply.Seq/maxttl].Addr = addr
-				hops[rply.Seq%maxttl][rply.Seq/maxttl].RTT = time.Since(sendOn[rply.Seq%maxttl][rply.Seq/maxttl])
-				hops[rply.Seq%maxttl][rply.Seq/maxttl].Final = true
-				hops[rply.Seq%maxttl][rply.Seq/maxttl].Timeout = false
+		case ipv4.ICMPTypeTimeExceeded, ipv6.ICMPTypeTimeExceeded,
+			ipv4.ICMPTypeDestinationUnreachable, ipv6.ICMPTypeDestinationUnreachable:
+			q, ok := quotedFrom(result.Body)
+			if !ok || !q.isEcho() {
+				continue
 			}
-		case ipv6.ICMPTypeDestinationUnreachable:
-			if rply, ok := result.Body.(*icmp.Echo); ok {
-				if icmpID != rply.ID {
-					continue
-				}
-				if maxSeq < rply.Seq {
-					continue
-				}
-
-				hops[rply.Seq%maxttl][rply.Seq/maxttl].Addr = addr
-				hops[rply.Seq%maxttl][rply.Seq/maxttl].RTT = time.Since(sendOn[rply.Seq%maxttl][rply.Seq/maxttl])
-				hops[rply.Seq%maxttl][rply.Seq/maxttl].Down = true
-				hops[rply.Seq%maxttl][rply.Seq/maxttl].Timeout = false
+			_, hop, r, ok := space.decode(q.id, q.seq)
+			if !ok {
+				continue
 			}
-		case ipv4.ICMPTypeDestinationUnreachable:
-			if rply, ok := result.Body.(*icmp.Echo); ok {
-				if icmpID != rply.ID {
-					continue
-				}
-				if maxSeq < rply.Seq {
-					continue
-				}
 
-				hops[rply.Seq%maxttl][rply.Seq/maxttl].Addr = addr
-				hops[rply.Seq%maxttl][rply.Seq/maxttl].RTT = time.Since(sendOn[rply.Seq%maxttl][rply.Seq/maxttl])
-				hops[rply.Seq%maxttl][rply.Seq/maxttl].Down = true
-				hops[rply.Seq%maxttl][rply.Seq/maxttl].Timeout = false
-			}
+			hops[hop][r].Addr = addr
+			hops[hop][r].RTT = time.Since(sendOn[hop][r])
+			hops[hop][r].Down = ipv4.ICMPTypeDestinationUnreachable == result.Type || ipv6.ICMPTypeDestinationUnreachable == result.Type
+			hops[hop][r].Timeout = false
+			setQuoted(&hops[hop][r], &q)
 		}
 	}
 
 	finalHop := maxttl
 	for hop := 0; hop < maxttl; hop++ {
 		for r := 0; r < rounds; r++ {
-			if nil == hops[hop][r].Addr {
-				continue
-			}
-			if nil != DNScache {
-				addrString := hops[hop][r].Addr.String()
-				hops[hop][r].Host = DNScache.LookupHost(addrString)
-				hops[hop][r].AS = DNScache.LookupAS(addrString)
-			}
 			if maxttl == finalHop && hops[hop][r].Final {
 				finalHop = hop + 1
 			}
-
 		}
 	}
 
-	return hops[:finalHop], nil
+	hops = hops[:finalHop]
+	enrichTrace(hops, DNScache)
+
+	return hops, dest, nil
 }
 
-// RunMPTrace preforms traceroute to many hosts by sending all packets at once using one (or 2) raw socket(s)
+// RunMPTrace preforms traceroute to many hosts by sending all packets at once using one (or 2) raw socket(s);
+// every host uses ceil(rounds*maxttl/65536) consecutive identifiers from startIcmpID (wrapping around 65535),
+// so concurrent traces must use identifier ranges which don't overlap;
+// hosts which can't be resolved are left out of result and listed by returned HostErrors together with
+// hops of all other hosts, RunMPTraceOpts reports them per host
 func RunMPTrace(hosts []string, source string, source6 string, maxrtt time.Duration, maxttl int, DNScache *LookupCache, rounds int, startIcmpID int, delay time.Duration) (map[string]*[][]Hop, error) {
+	results, err := runMPTrace(hosts, source, source6, maxrtt, maxttl, DNScache, rounds, startIcmpID, delay, nil)
+	if nil != err {
+		return nil, err
+	}
+
+	hops := make(map[string]*[][]Hop, len(results))
+	errs := HostErrors{}
+	for _, host := range hosts {
+		r := results[host]
+		if nil != r.Error {
+			errs[host] = r.Error
+			continue
+		}
+		hops[host] = &r.Hops
+	}
+
+	if len(errs) > 0 {
+		return hops, errs
+	}
+	return hops, nil
+}
+
+// RunMPTraceOpts preforms RunMPTrace with optional settings and returns
+// result per host; hosts which can't be resolved are reported with Error of
+// their result instead of failing whole batch
+func RunMPTraceOpts(hosts []string, source string, source6 string, maxrtt time.Duration, maxttl int, DNScache *LookupCache, rounds int, startIcmpID int, delay time.Duration, opts *Options) (map[string]*TraceResult, error) {
+	return runMPTrace(hosts, source, source6, maxrtt, maxttl, DNScache, rounds, startIcmpID, delay, opts)
+}
+
+// runMPTrace traces all hosts which can be resolved, resolution errors are
+// returned per host
+func runMPTrace(hosts []string, source string, source6 string, maxrtt time.Duration, maxttl int, DNScache *LookupCache, rounds int, startIcmpID int, delay time.Duration, opts *Options) (map[string]*TraceResult, error) {
+
+	start := time.Now()
 
-	hops := make(map[string]*[][]Hop, len(hosts))
-	sendOn := make(map[string]*[][]time.Time, len(hosts))
 	isIPv6 := make(map[string]bool, len(hosts))
 	dest := make(map[string]net.Addr, len(hosts))
 	addrs := make(map[string]string, len(hosts))
 	addrsb := make(map[string][]byte, len(hosts))
+	errs := make(map[string]error)
+
+	hasIPv4 := false
+	hasIPv6 := false
+
+	resolved := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+
+		addr, v6, err := resolveDest(host, opts.family(), opts.resolver())
+		if nil != err {
+			if errUnresolved == err {
+				err = errors.New("Unable to resolve destination host for " + host)
+			}
+			errs[host] = err
+			continue
+		}
+
+		dest[host] = addr
+		isIPv6[host] = v6
+		addrs[addr.IP.String()] = host
+		addrsb[host] = []byte(addr.IP.To16())
+		if v6 {
+			hasIPv6 = true
+		} else {
+			hasIPv4 = true
+		}
+		resolved = append(resolved, host)
+	}
+	hosts = resolved
+
+	// probes of resolved hosts only, so decoded host is always index of hosts
+	space, err := newProbeSpace(startIcmpID, len(hosts), maxttl, rounds)
+	if nil != err {
+		return nil, err
+	}
+
+	hops := make(map[string]*[][]Hop, len(hosts))
+	sendOn := make(map[string]*[][]time.Time, len(hosts))
 
 	for _, host := range hosts {
 
@@ -320,51 +325,6 @@ func RunMPTrace(hosts []string, source string, source6 string, maxrtt time.Durat
 		ipv6conn *ipv6.PacketConn
 	)
 
-	var err error
-
-	hasIPv4 := false
-	hasIPv6 := false
-
-	for _, host := range hosts {
-
-		addrList, err := net.LookupIP(host)
-		if nil != err {
-			return nil, err
-		}
-
-		for _, addr := range addrList {
-			if addr.To4() != nil {
-				dest[host], err = net.ResolveIPAddr("ip4:icmp", addr.String())
-				addrs[addr.String()] = host
-				addrsb[host] = []byte(addr.To16())
-				hasIPv4 = true
-				break
-			}
-		}
-
-		if nil == dest[host] {
-			for _, addr := range addrList {
-				if addr.To16() != nil {
-					isIPv6[host] = true
-					dest[host], err = net.ResolveIPAddr("ip6:58", addr.String())
-					addrs[addr.String()] = host
-					addrsb[host] = []byte(addr.To16())
-					hasIPv6 = true
-					break
-				}
-			}
-		}
-
-		if nil == dest[host] {
-			return nil, errors.New("Unable to resolve destination host for " + host)
-		}
-
-		if nil != err {
-			return nil, err
-		}
-
-	}
-
 	if hasIPv4 {
 		conn4, err = net.ListenPacket("ip4:icmp", source)
 		if nil != err {
@@ -436,7 +396,8 @@ func RunMPTrace(hosts []string, source string, source6 string, maxrtt time.Durat
 						icmpType = ipv4.ICMPTypeEcho
 					}
 
-					msg := icmp.Message{Type: icmpType, Code: 0, Body: &icmp.Echo{ID: startIcmpID + hostid, Seq: hop + (maxttl * r), Data: addrsb[host]}}
+					id, seq := space.encode(hostid, hop, r)
+					msg := icmp.Message{Type: icmpType, Code: 0, Body: &icmp.Echo{ID: id, Seq: seq, Data: addrsb[host]}}
 					netmsg, err := msg.Marshal(nil)
 					if nil != err {
 						for _, host := range hosts {
@@ -470,157 +431,68 @@ func RunMPTrace(hosts []string, source string, source6 string, maxrtt time.Durat
 	var wg sync.WaitGroup
 
 	// we have up to 2 sockets, so need 2 separate conn.ReadFrom threads
+	read := func(conn net.PacketConn, proto int) {
+		defer wg.Done()
 
-	maxICMPid := startIcmpID + len(hosts) - 1
-
-	if hasIPv4 {
-		wg.Add(1)
-
-		go func() {
-			defer wg.Done()
-
-			buf := make([]byte, 1500)
-
-			for mtime := time.Now().Add(maxrtt + (delay * time.Duration(maxSeq))); time.Now().Before(mtime); {
-
-				conn4.SetReadDeadline(mtime)
-				readLen, addr, err := conn4.ReadFrom(buf)
-				if nil != err {
-					break
-				}
+		buf := make([]byte, 1500)
 
-				result, err := icmp.ParseMessage(ProtocolICMP, buf[:readLen])
-				if nil != err {
-					continue // invalid icmp message
-				}
+		for mtime := time.Now().Add(maxrtt + (delay * time.Duration(maxSeq))); time.Now().Before(mtime); {
 
-				switch result.Type {
-				case ipv4.ICMPTypeEchoReply:
-					if rply, ok := result.Body.(*icmp.Echo); ok {
-
-						if rply.ID < startIcmpID || rply.ID > maxICMPid {
-							continue
-						}
-						if maxSeq < rply.Seq {
-							continue
-						}
+			conn.SetReadDeadline(mtime)
+			readLen, addr, err := conn.ReadFrom(buf)
+			if nil != err {
+				break
+			}
 
-						hopS := (*hops[hosts[rply.ID-startIcmpID]])
-						hopS[rply.Seq%maxttl][rply.Seq/maxttl].Addr = addr
-						hopS[rply.Seq%maxttl][rply.Seq/maxttl].RTT = time.Since((*sendOn[hosts[rply.ID-startIcmpID]])[rply.Seq%maxttl][rply.Seq/maxttl])
-						hopS[rply.Seq%maxttl][rply.Seq/maxttl].Final = true
-						hopS[rply.Seq%maxttl][rply.Seq/maxttl].Timeout = false
-					}
-				case ipv4.ICMPTypeTimeExceeded:
-					if rply, ok := result.Body.(*icmp.TimeExceeded); ok {
-						if len(rply.Data) > 26 {
-							id := int(binary.BigEndian.Uint16(rply.Data[24:26]))
-							if id < startIcmpID || id > maxICMPid {
-								continue
-							}
+			result, err := icmp.ParseMessage(proto, buf[:readLen])
+			if nil != err {
+				continue // invalid icmp message
+			}
 
-							seq := int(binary.BigEndian.Uint16(rply.Data[26:28]))
-							if maxSeq < seq {
-								continue
-							}
+			switch result.Type {
+			case ipv4.ICMPTypeEchoReply, ipv6.ICMPTypeEchoReply:
+				if rply, ok := result.Body.(*icmp.Echo); ok {
 
-							hopS := (*hops[hosts[id-startIcmpID]])
-							hopS[seq%maxttl][seq/maxttl].Addr = addr
-							hopS[seq%maxttl][seq/maxttl].RTT = time.Since((*sendOn[hosts[id-startIcmpID]])[seq%maxttl][seq/maxttl])
-							hopS[seq%maxttl][seq/maxttl].Timeout = false
-						}
+					hostid, hop, r, ok := space.decode(rply.ID, rply.Seq)
+					if !ok {
+						continue
 					}
-				case ipv4.ICMPTypeDestinationUnreachable:
-					if rply, ok := result.Body.(*icmp.Echo); ok {
-						if rply.ID < startIcmpID || rply.ID > maxICMPid {
-							continue
-						}
-						if maxSeq < rply.Seq {
-							continue
-						}
 
-						hopS := (*hops[hosts[rply.ID-startIcmpID]])
-						hopS[rply.Seq%maxttl][rply.Seq/maxttl].Addr = addr
-						hopS[rply.Seq%maxttl][rply.Seq/maxttl].RTT = time.Since((*sendOn[hosts[rply.ID-startIcmpID]])[rply.Seq%maxttl][rply.Seq/maxttl])
-						hopS[rply.Seq%maxttl][rply.Seq/maxttl].Down = true
-						hopS[rply.Seq%maxttl][rply.Seq/maxttl].Timeout = false
-					}
+					hopS := (*hops[hosts[hostid]])
+					hopS[hop][r].Addr = addr
+					hopS[hop][r].RTT = time.Since((*sendOn[hosts[hostid]])[hop][r])
+					hopS[hop][r].Final = true
+					hopS[hop][r].Timeout = false
+				}
+			case ipv4.ICMPTypeTimeExceeded, ipv6.ICMPTypeTimeExceeded,
+				ipv4.ICMPTypeDestinationUnreachable, ipv6.ICMPTypeDestinationUnreachable:
+				q, ok := quotedFrom(result.Body)
+				if !ok || !q.isEcho() {
+					continue
 				}
+				hostid, hop, r, ok := space.decode(q.id, q.seq)
+				if !ok {
+					continue
+				}
+
+				hopS := (*hops[hosts[hostid]])
+				hopS[hop][r].Addr = addr
+				hopS[hop][r].RTT = time.Since((*sendOn[hosts[hostid]])[hop][r])
+				hopS[hop][r].Down = ipv4.ICMPTypeDestinationUnreachable == result.Type || ipv6.ICMPTypeDestinationUnreachable == result.Type
+				hopS[hop][r].Timeout = false
+				setQuoted(&hopS[hop][r], &q)
 			}
+		}
+	}
 
-		}()
+	if hasIPv4 {
+		wg.Add(1)
+		go read(conn4, ProtocolICMP)
 	}
 
 	if hasIPv6 {
 		wg.Add(1)
-
-		go func() {
-			defer wg.Done()
-
-			buf := make([]byte, 1500)
-
-			for mtime := time.Now().Add(maxrtt + (delay * time.Duration(maxSeq))); time.Now().Before(mtime); {
-
-				conn6.SetReadDeadline(mtime)
-				readLen, addr, err := conn6.ReadFrom(buf)
-
-				if nil != err {
-					break
-				}
-
-				result, err := icmp.ParseMessage(ProtocolICMP6, buf[:readLen])
-				if nil != err {
-					continue // invalid icmp message
-				}
-
-				switch result.Type {
-				case ipv6.ICMPTypeTimeExceeded:
-					if rply, ok := result.Body.(*icmp.TimeExceeded); ok {
-						if len(rply.Data) > 46 {
-
-							id := int(binary.BigEndian.Uint16(rply.Data[44:46]))
-							seq := int(binary.BigEndian.Uint16(rply.Data[26:28]))
-
-							if id < startIcmpID || id > maxICMPid || maxSeq < seq {
-								continue
-							}
-
-							hopS := (*hops[hosts[id-startIcmpID]])
-							hopS[seq%maxttl][seq/maxttl].Addr = addr
-							hopS[seq%maxttl][seq/maxttl].RTT = time.Since((*sendOn[hosts[id-startIcmpID]])[seq%maxttl][seq/maxttl])
-							hopS[seq%maxttl][seq/maxttl].Timeout = false
-						}
-					}
-				case ipv6.ICMPTypeEchoReply:
-					if rply, ok := result.Body.(*icmp.Echo); ok {
-
-						if rply.ID < startIcmpID || rply.ID > maxICMPid || maxSeq < rply.Seq {
-							continue
-						}
-
-						hopS := (*hops[hosts[rply.ID-startIcmpID]])
-						hopS[rply.Seq%maxttl][rply.Seq/maxttl].Addr = addr
-						hopS[rply.Seq%maxttl][rply.Seq/maxttl].RTT = time.Since((*sendOn[hosts[rply.ID-startIcmpID]])[rply.Seq%maxttl][rply.Seq/maxttl])
-						hopS[rply.Seq%maxttl][rply.Seq/maxttl].Final = true
-						hopS[rply.Seq%maxttl][rply.Seq/maxttl].Timeout = false
-					}
-				case ipv6.ICMPTypeDestinationUnreachable:
-					if rply, ok := result.Body.(*icmp.Echo); ok {
-
-						if rply.ID < startIcmpID || rply.ID > maxICMPid || maxSeq < rply.Seq {
-							continue
-						}
-
-						hopS := (*hops[hosts[rply.ID-startIcmpID]])
-						hopS[rply.Seq%maxttl][rply.Seq/maxttl].Addr = addr
-						hopS[rply.Seq%maxttl][rply.Seq/maxttl].RTT = time.Since((*sendOn[hosts[rply.ID-startIcmpID]])[rply.Seq%maxttl][rply.Seq/maxttl])
-						hopS[rply.Seq%maxttl][rply.Seq/maxttl].Down = true
-						hopS[rply.Seq%maxttl][rply.Seq/maxttl].Timeout = false
-					}
-				}
-			}
-
-		}()
+		go read(conn6, ProtocolICMP6)
 	}
 
 	wg.Wait()
@@ -631,14 +503,6 @@ func RunMPTrace(hosts []string, source string, source6 string, maxrtt time.Durat
 
 		for hop := 0; hop < maxttl; hop++ {
 			for r := 0; r < rounds; r++ {
-				if nil == hopS[hop][r].Addr {
-					continue
-				}
-				if nil != DNScache {
-					addrString := hopS[hop][r].Addr.String()
-					hopS[hop][r].Host = DNScache.LookupHost(addrString)
-					hopS[hop][r].AS = DNScache.LookupAS(addrString)
-				}
 				if maxttl == finalHop && hopS[hop][r].Final {
 					finalHop = hop + 1
 				}
@@ -648,5 +512,23 @@ func RunMPTrace(hosts []string, source string, source6 string, maxrtt time.Durat
 		hops[host] = &(hopS)
 	}
 
-	return hops, nil
+	// all hosts are enriched together so addresses shared between
+	// traces are looked up only once
+	all := make([][]Hop, 0, len(hosts)*maxttl)
+	for _, host := range hosts {
+		all = append(all, (*hops[host])...)
+	}
+	enrichTrace(all, DNScache)
+
+	result := make(map[string]*TraceResult, len(hosts)+len(errs))
+	for host, err := range errs {
+		result[host] = &TraceResult{Error: err, Start: start, Duration: time.Since(start)}
+	}
+	for _, host := range hosts {
+		hostHops := *hops[host]
+		result[host] = newTraceResult(hostHops, DetectMultiLoop(hostHops, opts.loopRepeats()), start)
+		result[host].Dest = dest[host]
+	}
+
+	return result, nil
 }
@@ -0,0 +1,170 @@
+package tracelib
+
+import (
+	"encoding/binary"
+	"net"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// IP protocol numbers of quoted upper layer headers
+const (
+	protocolTCP = 6
+	protocolUDP = 17
+)
+
+// IPv6 extension headers which can precede upper layer header
+const (
+	ipv6HopByHop    = 0
+	ipv6Routing     = 43
+	ipv6Fragment    = 44
+	ipv6AH          = 51
+	ipv6DestOptions = 60
+	ipv6Mobility    = 135
+)
+
+// quotedPacket is probe quoted in ICMP error message
+type quotedPacket struct {
+	ipv6  bool
+	src   net.IP
+	dst   net.IP
+	ttl   int
+	proto int
+	// ICMP (ICMPv6) echo fields
+	icmpType int
+	id       int
+	seq      int
+	// UDP and TCP ports, tcpSeq for TCP only
+	srcPort int
+	dstPort int
+	tcpSeq  uint32
+	// checksum of ICMP, UDP or TCP header
+	checksum uint16
+	// extensions of ICMP error message (RFC 4884)
+	extensions []icmp.Extension
+}
+
+// isEcho returns true if quoted packet is ICMP or ICMPv6 echo request
+func (q *quotedPacket) isEcho() bool {
+	if q.ipv6 {
+		return ProtocolICMP6 == q.proto && int(ipv6.ICMPTypeEchoRequest) == q.icmpType
+	}
+	return ProtocolICMP == q.proto && int(ipv4.ICMPTypeEcho) == q.icmpType
+}
+
+// quotedFrom decodes probe quoted in time exceeded or destination
+// unreachable message body
+func quotedFrom(body icmp.MessageBody) (quotedPacket, bool) {
+	switch b := body.(type) {
+	case *icmp.TimeExceeded:
+		q, ok := parseQuoted(b.Data)
+		q.extensions = b.Extensions
+		return q, ok
+	case *icmp.DstUnreach:
+		q, ok := parseQuoted(b.Data)
+		q.extensions = b.Extensions
+		return q, ok
+	}
+	return quotedPacket{}, false
+}
+
+// parseQuoted decodes IPv4 (with options) or IPv6 (with extension headers)
+// header and first 8 bytes of ICMP, UDP or TCP header following it
+func parseQuoted(data []byte) (quotedPacket, bool) {
+	var (
+		q  quotedPacket
+		l4 []byte
+	)
+
+	if len(data) < 1 {
+		return q, false
+	}
+
+	switch data[0] >> 4 {
+	case 4:
+		if len(data) < ipv4.HeaderLen {
+			return q, false
+		}
+		ihl := int(data[0]&0x0f) * 4
+		if ihl < ipv4.HeaderLen || len(data) < ihl {
+			return q, false
+		}
+		q.src = net.IP(data[12:16])
+		q.dst = net.IP(data[16:20])
+		q.ttl = int(data[8])
+		q.proto = int(data[9])
+		l4 = data[ihl:]
+	case 6:
+		if len(data) < ipv6.HeaderLen {
+			return q, false
+		}
+		q.ipv6 = true
+		q.src = net.IP(data[8:24])
+		q.dst = net.IP(data[24:40])
+		q.ttl = int(data[7])
+
+		next := int(data[6])
+		off := ipv6.HeaderLen
+		for {
+			var l int
+			switch next {
+			case ipv6HopByHop, ipv6Routing, ipv6DestOptions, ipv6Mobility:
+				if len(data) < off+2 {
+					return q, false
+				}
+				l = (int(data[off+1]) + 1) * 8
+			case ipv6Fragment:
+				l = 8
+			case ipv6AH:
+				if len(data) < off+2 {
+					return q, false
+				}
+				l = (int(data[off+1]) + 2) * 4
+			}
+			if 0 == l {
+				break
+			}
+			if len(data) < off+l {
+				return q, false
+			}
+			next = int(data[off])
+			off += l
+		}
+		q.proto = next
+		l4 = data[off:]
+	default:
+		return q, false
+	}
+
+	// copy addresses, data is usually read buffer reused for next message
+	q.src = append(net.IP(nil), q.src...)
+	q.dst = append(net.IP(nil), q.dst...)
+
+	if len(l4) < 8 {
+		return q, false
+	}
+
+	switch q.proto {
+	case ProtocolICMP, ProtocolICMP6:
+		q.icmpType = int(l4[0])
+		q.checksum = binary.BigEndian.Uint16(l4[2:4])
+		q.id = int(binary.BigEndian.Uint16(l4[4:6]))
+		q.seq = int(binary.BigEndian.Uint16(l4[6:8]))
+	case protocolUDP:
+		q.srcPort = int(binary.BigEndian.Uint16(l4[0:2]))
+		q.dstPort = int(binary.BigEndian.Uint16(l4[2:4]))
+		q.checksum = binary.BigEndian.Uint16(l4[6:8])
+	case protocolTCP:
+		q.srcPort = int(binary.BigEndian.Uint16(l4[0:2]))
+		q.dstPort = int(binary.BigEndian.Uint16(l4[2:4]))
+		q.tcpSeq = binary.BigEndian.Uint32(l4[4:8])
+		// TCP checksum is beyond 8 bytes guaranteed to be quoted
+		if len(l4) >= 18 {
+			q.checksum = binary.BigEndian.Uint16(l4[16:18])
+		}
+	}
+
+	return q, true
+}
@@ -0,0 +1,136 @@
+package tracelib
+
+import (
+	"net"
+	"testing"
+
+	"golang.org/x/net/icmp"
+)
+
+// quotedIPv4 returns IPv4 header with options followed by l4
+func quotedIPv4(proto byte, options []byte, l4 []byte) []byte {
+	ihl := 20 + len(options)
+	b := make([]byte, ihl, ihl+len(l4))
+	b[0] = 0x40 | byte(ihl/4)
+	b[8] = 1 // TTL
+	b[9] = proto
+	copy(b[12:16], net.IPv4(192, 0, 2, 1).To4())
+	copy(b[16:20], net.IPv4(198, 51, 100, 7).To4())
+	copy(b[20:], options)
+	return append(b, l4...)
+}
+
+// quotedIPv6 returns IPv6 header with next header next followed by rest
+func quotedIPv6(next byte, rest []byte) []byte {
+	b := make([]byte, 40, 40+len(rest))
+	b[0] = 0x60
+	b[6] = next
+	b[7] = 2 // hop limit
+	copy(b[8:24], net.ParseIP("2001:db8::1"))
+	copy(b[24:40], net.ParseIP("2001:db8::2"))
+	return append(b, rest...)
+}
+
+func TestParseQuoted(t *testing.T) {
+	echo4 := []byte{8, 0, 0xab, 0xcd, 0x12, 0x34, 0x00, 0x07}
+	echo6 := []byte{128, 0, 0xab, 0xcd, 0x12, 0x34, 0x01, 0x00}
+	udp := []byte{0x82, 0x9a, 0x82, 0x9b, 0x00, 0x10, 0xbe, 0xef}
+	// record route option of 3 slots with NOP padding
+	rr := []byte{7, 15, 4, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}
+	hopByHop := []byte{44, 0, 1, 4, 0, 0, 0, 0}
+	fragment := []byte{58, 0, 0, 1, 0, 0, 0, 42}
+
+	tests := []struct {
+		name     string
+		data     []byte
+		ipv6     bool
+		proto    int
+		id, seq  int
+		ports    [2]int
+		checksum uint16
+		ttl      int
+	}{
+		{"IPv4 echo", quotedIPv4(ProtocolICMP, nil, echo4), false, ProtocolICMP, 0x1234, 7, [2]int{}, 0xabcd, 1},
+		{"IPv4 echo with options", quotedIPv4(ProtocolICMP, rr, echo4), false, ProtocolICMP, 0x1234, 7, [2]int{}, 0xabcd, 1},
+		{"IPv4 UDP with options", quotedIPv4(protocolUDP, rr, udp), false, protocolUDP, 0, 0, [2]int{33434, 33435}, 0xbeef, 1},
+		{"IPv6 echo", quotedIPv6(ProtocolICMP6, echo6), true, ProtocolICMP6, 0x1234, 256, [2]int{}, 0xabcd, 2},
+		{"IPv6 echo with hop-by-hop and fragment", quotedIPv6(0, append(append(append([]byte(nil), hopByHop...), fragment...), echo6...)), true, ProtocolICMP6, 0x1234, 256, [2]int{}, 0xabcd, 2},
+		{"IPv6 UDP", quotedIPv6(protocolUDP, udp), true, protocolUDP, 0, 0, [2]int{33434, 33435}, 0xbeef, 2},
+	}
+
+	for _, test := range tests {
+		q, ok := parseQuoted(test.data)
+		if !ok {
+			t.Errorf("%s: not parsed", test.name)
+			continue
+		}
+		if test.ipv6 != q.ipv6 || test.proto != q.proto || test.ttl != q.ttl {
+			t.Errorf("%s: unexpected header ipv6 %v proto %d ttl %d", test.name, q.ipv6, q.proto, q.ttl)
+		}
+		if test.id != q.id || test.seq != q.seq {
+			t.Errorf("%s: expected id/seq %d/%d, got %d/%d", test.name, test.id, test.seq, q.id, q.seq)
+		}
+		if test.ports[0] != q.srcPort || test.ports[1] != q.dstPort {
+			t.Errorf("%s: expected ports %v, got %d/%d", test.name, test.ports, q.srcPort, q.dstPort)
+		}
+		if test.checksum != q.checksum {
+			t.Errorf("%s: expected checksum %#x, got %#x", test.name, test.checksum, q.checksum)
+		}
+		if ProtocolICMP == test.proto || ProtocolICMP6 == test.proto {
+			if !q.isEcho() {
+				t.Errorf("%s: echo request not recognized", test.name)
+			}
+		}
+
+		dst := net.ParseIP("198.51.100.7")
+		if test.ipv6 {
+			dst = net.ParseIP("2001:db8::2")
+		}
+		if !q.dst.Equal(dst) {
+			t.Errorf("%s: expected destination %v, got %v", test.name, dst, q.dst)
+		}
+	}
+}
+
+func TestParseQuotedTruncated(t *testing.T) {
+	full4 := quotedIPv4(ProtocolICMP, []byte{1, 1, 1, 0}, []byte{8, 0, 0, 0, 0, 1, 0, 1})
+	bad4 := append([]byte(nil), full4...)
+	bad4[0] = 0x44 // IHL shorter than header
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"unknown version", []byte{0x50, 0, 0, 0}},
+		{"short IPv4 header", full4[:19]},
+		{"IPv4 options cut", full4[:22]},
+		{"IPv4 invalid IHL", bad4},
+		{"IPv4 upper layer cut", full4[:len(full4)-1]},
+		{"short IPv6 header", quotedIPv6(ProtocolICMP6, nil)[:39]},
+		{"IPv6 extension header cut", quotedIPv6(0, []byte{58, 1, 0, 0, 0, 0, 0, 0})},
+		{"IPv6 upper layer cut", quotedIPv6(ProtocolICMP6, []byte{128, 0, 0, 0, 0, 1})},
+	}
+
+	for _, test := range tests {
+		if _, ok := parseQuoted(test.data); ok {
+			t.Errorf("%s: expected false", test.name)
+		}
+	}
+}
+
+func TestQuotedFrom(t *testing.T) {
+	data := quotedIPv4(ProtocolICMP, nil, []byte{8, 0, 0, 0, 0, 5, 0, 9})
+
+	q, ok := quotedFrom(&icmp.TimeExceeded{Data: data})
+	if !ok || 5 != q.id || 9 != q.seq {
+		t.Errorf("time exceeded: unexpected %+v (%v)", q, ok)
+	}
+	q, ok = quotedFrom(&icmp.DstUnreach{Data: data})
+	if !ok || 5 != q.id || 9 != q.seq {
+		t.Errorf("destination unreachable: unexpected %+v (%v)", q, ok)
+	}
+	if _, ok := quotedFrom(&icmp.Echo{ID: 5, Seq: 9}); ok {
+		t.Error("echo body decoded as quoted probe")
+	}
+}
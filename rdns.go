@@ -0,0 +1,125 @@
+package tracelib
+
+import (
+	"net"
+	"strings"
+	"time"
+)
+
+// HostInfo is result of reverse DNS lookup of hop address
+type HostInfo struct {
+	// Host is first confirmed name or first valid name if none is confirmed
+	Host string
+	// Names are all syntactically valid PTR names
+	Names []string
+	// Confirmed is true if Host resolves back to the address
+	// (checked only with LookupCache.ConfirmHosts enabled)
+	Confirmed bool
+}
+
+// LookupHostInfo returns all PTR names of IP, with ConfirmHosts enabled
+// names are also forward-confirmed; non-routable addresses are skipped
+// unless LookupPrivateHosts is set
+func (cache *LookupCache) LookupHostInfo(ip string) HostInfo {
+	if !cache.LookupPrivateHosts && !ClassifyAddr(net.ParseIP(ip)).Routable() {
+		return HostInfo{}
+	}
+
+	cache.hMutex.RLock()
+	v, exist := cache.hosts[ip]
+	cache.hMutex.RUnlock()
+	// names stored without confirmation are looked up again if needed
+	if exist && !cache.expired(v.Time) && (v.Checked || !cache.ConfirmHosts || len(v.Names) == 0) {
+		return HostInfo{Host: v.Host, Names: v.Names, Confirmed: v.Confirmed}
+	}
+
+	return cache.do("host:"+ip, func() interface{} {
+		confirm := cache.ConfirmHosts
+		result := lookupHostInfo(ip, confirm)
+
+		cache.hMutex.Lock()
+		cache.hosts[ip] = hostEntry{Host: result.Host, Names: result.Names, Confirmed: result.Confirmed, Checked: confirm, Time: time.Now()}
+		cache.hMutex.Unlock()
+
+		return result
+	}).(HostInfo)
+}
+
+// lookupHostInfo does actual reverse lookup and optional confirmation
+func lookupHostInfo(ip string, confirm bool) HostInfo {
+	var result HostInfo
+
+	addrs, _ := net.LookupAddr(ip)
+	for _, name := range addrs {
+		if validHostname(name) {
+			result.Names = append(result.Names, name)
+		}
+	}
+
+	if len(result.Names) == 0 {
+		return result
+	}
+	result.Host = result.Names[0]
+
+	if !confirm {
+		return result
+	}
+
+	for _, name := range result.Names {
+		if forwardConfirmed(name, ip) {
+			result.Host = name
+			result.Confirmed = true
+			break
+		}
+	}
+
+	return result
+}
+
+// forwardConfirmed checks if name resolves back to ip
+func forwardConfirmed(name string, ip string) bool {
+	addr := net.ParseIP(ip)
+	if nil == addr {
+		return false
+	}
+
+	ips, err := net.LookupIP(name)
+	if nil != err {
+		return false
+	}
+
+	for _, i := range ips {
+		if i.Equal(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validHostname checks DNS name syntax (RFC 1123 labels, underscore is
+// allowed as it's often seen in PTR records)
+func validHostname(name string) bool {
+	name = strings.TrimSuffix(name, ".")
+	if len(name) == 0 || len(name) > 253 {
+		return false
+	}
+
+	for _, label := range strings.Split(name, ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return false
+		}
+		if '-' == label[0] || '-' == label[len(label)-1] {
+			return false
+		}
+		for _, c := range label {
+			switch {
+			case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+			default:
+				return false
+			}
+		}
+	}
+
+	return true
+}
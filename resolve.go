@@ -0,0 +1,59 @@
+package tracelib
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+)
+
+// Resolver resolves hostnames of targets, *net.Resolver implements it
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// HostIP returns target for pre-resolved address, IP literals are used by
+// all Run* functions directly without resolving
+func HostIP(ip net.IP) string {
+	return ip.String()
+}
+
+// lookupHost returns IP literal host as is, otherwise resolves it using
+// resolver (net.DefaultResolver if nil)
+func lookupHost(host string, resolver Resolver) ([]net.IP, error) {
+	if ip := net.ParseIP(host); nil != ip {
+		return []net.IP{ip}, nil
+	}
+
+	if nil == resolver {
+		resolver = net.DefaultResolver
+	}
+
+	addrs, err := resolver.LookupIPAddr(context.Background(), host)
+	if nil != err {
+		return nil, err
+	}
+
+	ips := make([]net.IP, 0, len(addrs))
+	for _, addr := range addrs {
+		ips = append(ips, addr.IP)
+	}
+	return ips, nil
+}
+
+// HostErrors lists hosts which couldn't be traced with their errors
+type HostErrors map[string]error
+
+func (e HostErrors) Error() string {
+	hosts := make([]string, 0, len(e))
+	for host := range e {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	msgs := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		msgs = append(msgs, host+": "+e[host].Error())
+	}
+	return "Unable to trace " + strings.Join(msgs, "; ")
+}
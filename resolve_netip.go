@@ -0,0 +1,12 @@
+//go:build go1.18
+// +build go1.18
+
+package tracelib
+
+import "net/netip"
+
+// HostAddr returns target for pre-resolved address, zone is dropped; it's
+// available only with Go 1.18 and newer
+func HostAddr(addr netip.Addr) string {
+	return addr.WithZone("").Unmap().String()
+}
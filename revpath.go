@@ -0,0 +1,167 @@
+package tracelib
+
+import (
+	"net"
+	"time"
+)
+
+// Confidence is confidence level of inference
+type Confidence int
+
+const (
+	// ConfidenceNone means there is no data for inference
+	ConfidenceNone Confidence = iota
+	// ConfidenceLow is inference based on one weak evidence
+	ConfidenceLow
+	// ConfidenceMedium is inference based on two evidences
+	ConfidenceMedium
+	// ConfidenceHigh is inference based on all evidences or recorded route
+	ConfidenceHigh
+)
+
+func (c Confidence) String() string {
+	switch c {
+	case ConfidenceNone:
+		return "none"
+	case ConfidenceLow:
+		return "low"
+	case ConfidenceMedium:
+		return "medium"
+	case ConfidenceHigh:
+		return "high"
+	}
+	return "invalid"
+}
+
+// ReverseRTTJump is minimal RTT increase from previous hop considered as
+// RTT discontinuity
+const ReverseRTTJump = 5 * time.Millisecond
+
+// ReverseHop is reverse path estimate of one hop
+type ReverseHop struct {
+	// Hop is 1-based hop number, also length of forward path
+	Hop int
+	// ReturnLength is length of return path estimated from reply TTL, 0 if
+	// unknown; Asymmetry is ReturnLength - Hop
+	ReturnLength int
+	Asymmetry    int
+	// Shift is true if asymmetry differs from first responding hop
+	Shift bool
+	// RTTJump is true if RTT rises by ReverseRTTJump from previous hop
+	RTTJump bool
+	// Recorded is true if hop address was found in recorded reverse route
+	Recorded bool
+	// Diverged is true if return path of hop likely doesn't follow reverse
+	// of forward path; DivergeConfidence is confidence of divergence and
+	// SymmetricConfidence of following forward path, the other one is
+	// ConfidenceNone
+	Diverged            bool
+	DivergeConfidence   Confidence
+	SymmetricConfidence Confidence
+}
+
+// ReversePath is result of AnalyzeReversePath
+type ReversePath struct {
+	// Hops are estimates of responding hops
+	Hops []ReverseHop
+	// DivergeHop is 1-based number of first hop with diverged return path,
+	// 0 if none was found, DivergeConfidence is its confidence
+	DivergeHop        int
+	DivergeConfidence Confidence
+}
+
+// AnalyzeReversePath estimates where return path diverges from forward one
+// using reply TTLs, RTT discontinuities and optional addresses of reverse
+// route recorded by IP Record Route or Timestamp options (nil if not
+// probed); hop missing from recorded route counts as evidence only if it's
+// within recorded length before last hop, as option slots are shared with
+// forward path; sets Reverse of all responders of analysed hops
+func AnalyzeReversePath(hops [][]MHop, recorded []net.IP) ReversePath {
+	var (
+		result   ReversePath
+		prev     *MHop
+		baseline int
+		hasBase  bool
+		indexes  []int
+	)
+
+	inRecorded := make(map[string]bool, len(recorded))
+	for _, ip := range recorded {
+		inRecorded[ip.String()] = true
+	}
+
+	for i, hop := range hops {
+		h := bestResponder(hop)
+		if nil == h {
+			continue
+		}
+
+		r := ReverseHop{Hop: i + 1}
+		evidence := 0
+
+		if h.ReplyTTL > 0 {
+			r.ReturnLength = returnPathLength(h.ReplyTTL)
+			r.Asymmetry = r.ReturnLength - r.Hop
+			if !hasBase {
+				baseline, hasBase = r.Asymmetry, true
+			}
+			r.Shift = r.Asymmetry != baseline
+		}
+		if r.Shift {
+			evidence++
+		}
+
+		r.RTTJump = nil != prev && h.MinRTT-prev.MinRTT >= ReverseRTTJump
+		if r.RTTJump {
+			evidence++
+		}
+
+		for _, resp := range hop {
+			if ip := addrIP(resp.Addr); nil != ip && inRecorded[ip.String()] {
+				r.Recorded = true
+			}
+		}
+		// reverse route starts next to destination (last hop)
+		if !r.Recorded && r.Hop < len(hops) && r.Hop >= len(hops)-len(recorded) {
+			evidence++
+		}
+
+		switch {
+		case r.Recorded:
+			r.SymmetricConfidence = ConfidenceHigh
+		case 0 == evidence && h.ReplyTTL > 0:
+			r.SymmetricConfidence = ConfidenceMedium
+		case 0 == evidence:
+			r.SymmetricConfidence = ConfidenceLow
+		default:
+			r.Diverged = true
+			r.DivergeConfidence = Confidence(evidence)
+		}
+
+		result.Hops = append(result.Hops, r)
+		indexes = append(indexes, i)
+		prev = h
+	}
+
+	for _, r := range result.Hops {
+		if !r.Diverged {
+			continue
+		}
+		if 0 == result.DivergeHop {
+			result.DivergeHop, result.DivergeConfidence = r.Hop, r.DivergeConfidence
+		}
+		// prefer stronger evidence over first weak one
+		if r.DivergeConfidence > ConfidenceLow {
+			result.DivergeHop, result.DivergeConfidence = r.Hop, r.DivergeConfidence
+			break
+		}
+	}
+
+	for k, i := range indexes {
+		for j := range hops[i] {
+			hops[i][j].Reverse = &result.Hops[k]
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,320 @@
+package tracelib
+
+import (
+	"sort"
+	"time"
+)
+
+// HopChange describes difference of one hop between two traces
+type HopChange struct {
+	// OldHop and NewHop are 1-based hop numbers, 0 if hop is missing
+	OldHop int
+	NewHop int
+	// Added and Removed are responders seen only in new or old trace
+	Added   []string
+	Removed []string
+	// ResponderChanged is true if responder sets have nothing in common,
+	// partial difference is considered ECMP variation
+	ResponderChanged bool
+	// RTTShift is difference of best MinRTT of new and old hop
+	RTTShift   time.Duration
+	RTTChanged bool
+}
+
+// PathDiff is result of comparison of two aggregated traces
+type PathDiff struct {
+	// Hops contains only hops with any difference
+	Hops        []HopChange
+	HopsAdded   int
+	HopsRemoved int
+	OldASPath   []int64
+	NewASPath   []int64
+
+	PathChanged   bool
+	ASPathChanged bool
+	RTTChanged    bool
+	Changed       bool
+}
+
+// CompareOptions sets thresholds of path comparison
+type CompareOptions struct {
+	// RTTThreshold is minimal absolute RTT shift considered change, 10ms if 0
+	RTTThreshold time.Duration
+	// RTTRatio is minimal relative RTT shift considered change, 0.5 if 0
+	RTTRatio float64
+	// IgnoreRTT disables RTT comparison at all
+	IgnoreRTT bool
+}
+
+// hopResponders returns set of responders and best MinRTT of hop
+func hopResponders(hop []MHop) (map[string]bool, time.Duration) {
+	result := make(map[string]bool, len(hop))
+	var best time.Duration
+
+	for _, h := range hop {
+		if nil == h.Addr || h.Total == h.Lost {
+			continue
+		}
+		result[h.Addr.String()] = true
+		if 0 == best || (h.MinRTT > 0 && h.MinRTT < best) {
+			best = h.MinRTT
+		}
+	}
+
+	return result, best
+}
+
+// hop alignment operations
+const (
+	alignPair = iota
+	alignOld
+	alignNew
+)
+
+// alignHops aligns hops of both traces like in sequence alignment: hops
+// sharing responders are matched, non-responding hops match anything,
+// differing hops are paired or treated as added/removed whichever fits better
+func alignHops(old, new []map[string]bool) []int {
+	score := func(a, b map[string]bool) int {
+		if len(a) == 0 || len(b) == 0 {
+			return 1
+		}
+		for k := range a {
+			if b[k] {
+				return 2
+			}
+		}
+		return 0
+	}
+
+	n, m := len(old), len(new)
+	dp := make([][]int, n+1)
+	for i := range dp {
+		dp[i] = make([]int, m+1)
+		dp[i][0] = -i
+	}
+	for j := 0; j <= m; j++ {
+		dp[0][j] = -j
+	}
+
+	for i := 1; i <= n; i++ {
+		for j := 1; j <= m; j++ {
+			best := dp[i-1][j-1] + score(old[i-1], new[j-1])
+			if v := dp[i-1][j] - 1; v > best {
+				best = v
+			}
+			if v := dp[i][j-1] - 1; v > best {
+				best = v
+			}
+			dp[i][j] = best
+		}
+	}
+
+	// backtrack, ops are collected in reverse order
+	ops := make([]int, 0, n+m)
+	for i, j := n, m; i > 0 || j > 0; {
+		switch {
+		case i > 0 && j > 0 && dp[i][j] == dp[i-1][j-1]+score(old[i-1], new[j-1]):
+			ops = append(ops, alignPair)
+			i--
+			j--
+		case i > 0 && dp[i][j] == dp[i-1][j]-1:
+			ops = append(ops, alignOld)
+			i--
+		default:
+			ops = append(ops, alignNew)
+			j--
+		}
+	}
+
+	for l, r := 0, len(ops)-1; l < r; l, r = l+1, r-1 {
+		ops[l], ops[r] = ops[r], ops[l]
+	}
+
+	return ops
+}
+
+// ComparePaths compares two aggregated traces of same destination
+func ComparePaths(old, new [][]MHop, opts CompareOptions) PathDiff {
+	if 0 == opts.RTTThreshold {
+		opts.RTTThreshold = 10 * time.Millisecond
+	}
+	if 0 == opts.RTTRatio {
+		opts.RTTRatio = 0.5
+	}
+
+	var diff PathDiff
+
+	oldSets := make([]map[string]bool, len(old))
+	oldRTT := make([]time.Duration, len(old))
+	for i, hop := range old {
+		oldSets[i], oldRTT[i] = hopResponders(hop)
+	}
+	newSets := make([]map[string]bool, len(new))
+	newRTT := make([]time.Duration, len(new))
+	for i, hop := range new {
+		newSets[i], newRTT[i] = hopResponders(hop)
+	}
+
+	i, j := 0, 0
+	for _, op := range alignHops(oldSets, newSets) {
+		var change HopChange
+
+		switch op {
+		case alignOld:
+			i++
+			if len(oldSets[i-1]) == 0 {
+				continue
+			}
+			change.OldHop = i
+			change.Removed = setKeys(oldSets[i-1])
+			diff.HopsRemoved++
+		case alignNew:
+			j++
+			if len(newSets[j-1]) == 0 {
+				continue
+			}
+			change.NewHop = j
+			change.Added = setKeys(newSets[j-1])
+			diff.HopsAdded++
+		default:
+			i++
+			j++
+			o, n := oldSets[i-1], newSets[j-1]
+			if len(o) == 0 || len(n) == 0 {
+				continue
+			}
+			change.OldHop = i
+			change.NewHop = j
+			change.Added = setDiff(n, o)
+			change.Removed = setDiff(o, n)
+			change.ResponderChanged = len(change.Removed) == len(o)
+
+			if !opts.IgnoreRTT && oldRTT[i-1] > 0 && newRTT[j-1] > 0 {
+				change.RTTShift = newRTT[j-1] - oldRTT[i-1]
+				shift := change.RTTShift
+				if shift < 0 {
+					shift = -shift
+				}
+				change.RTTChanged = shift >= opts.RTTThreshold &&
+					float64(shift) >= opts.RTTRatio*float64(oldRTT[i-1])
+			}
+
+			if len(change.Added) == 0 && len(change.Removed) == 0 && !change.RTTChanged {
+				continue
+			}
+		}
+
+		diff.PathChanged = diff.PathChanged || change.ResponderChanged || 0 == change.OldHop || 0 == change.NewHop
+		diff.RTTChanged = diff.RTTChanged || change.RTTChanged
+		diff.Hops = append(diff.Hops, change)
+	}
+
+	diff.OldASPath = ASPathFromMulti(old).ASNumbers()
+	diff.NewASPath = ASPathFromMulti(new).ASNumbers()
+	diff.ASPathChanged = !equalASPath(diff.OldASPath, diff.NewASPath)
+
+	diff.Changed = diff.PathChanged || diff.ASPathChanged || diff.RTTChanged
+
+	return diff
+}
+
+func equalASPath(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func setKeys(s map[string]bool) []string {
+	return setDiff(s, nil)
+}
+
+// setDiff returns sorted elements of a missing in b
+func setDiff(a, b map[string]bool) []string {
+	var result []string
+	for k := range a {
+		if !b[k] {
+			result = append(result, k)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// RouteEvent is reported by RouteMonitor when path change persists
+type RouteEvent struct {
+	Time time.Time
+	// Diff is difference between previous stable path and new one
+	Diff PathDiff
+	// Hops is new stable path
+	Hops [][]MHop
+}
+
+// RouteMonitor tracks successive traces of one destination and reports
+// only changes which persist in several consecutive traces
+type RouteMonitor struct {
+	options     CompareOptions
+	persistence int
+
+	baseline [][]MHop
+	pending  [][]MHop
+	count    int
+}
+
+// NewRouteMonitor creates monitor, change must be seen in persistence
+// consecutive traces to be reported (1 reports every change)
+func NewRouteMonitor(opts CompareOptions, persistence int) *RouteMonitor {
+	if persistence < 1 {
+		persistence = 1
+	}
+	return &RouteMonitor{options: opts, persistence: persistence}
+}
+
+// Update processes next trace and returns event if path change is confirmed,
+// first trace becomes baseline
+func (m *RouteMonitor) Update(hops [][]MHop) *RouteEvent {
+	if nil == m.baseline {
+		m.baseline = hops
+		return nil
+	}
+
+	if !ComparePaths(m.baseline, hops, m.options).Changed {
+		m.pending = nil
+		m.count = 0
+		return nil
+	}
+
+	if nil != m.pending && !ComparePaths(m.pending, hops, m.options).Changed {
+		m.count++
+	} else {
+		m.pending = hops
+		m.count = 1
+	}
+
+	if m.count < m.persistence {
+		return nil
+	}
+
+	event := &RouteEvent{
+		Time: time.Now(),
+		Diff: ComparePaths(m.baseline, hops, m.options),
+		Hops: hops,
+	}
+
+	m.baseline = hops
+	m.pending = nil
+	m.count = 0
+
+	return event
+}
+
+// Baseline returns current stable path
+func (m *RouteMonitor) Baseline() [][]MHop {
+	return m.baseline
+}
@@ -0,0 +1,75 @@
+package tracelib
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// setStats calculates RTT statistics from RTTs of replies in order they
+// were sent
+func (mhop *MHop) setStats(rtts []time.Duration) {
+	if len(rtts) == 0 {
+		return
+	}
+
+	var sum time.Duration
+	mhop.MinRTT = rtts[0]
+	mhop.MaxRTT = rtts[0]
+	for _, rtt := range rtts {
+		sum += rtt
+		if rtt < mhop.MinRTT {
+			mhop.MinRTT = rtt
+		}
+		if rtt > mhop.MaxRTT {
+			mhop.MaxRTT = rtt
+		}
+	}
+	mhop.AvgRTT = sum / time.Duration(len(rtts))
+	mhop.LastRTT = rtts[len(rtts)-1]
+
+	var variance float64
+	for _, rtt := range rtts {
+		d := float64(rtt - mhop.AvgRTT)
+		variance += d * d
+	}
+	mhop.StdDev = time.Duration(math.Sqrt(variance / float64(len(rtts))))
+
+	// jitter is difference between consecutive replies like in mtr
+	if len(rtts) > 1 {
+		var jsum time.Duration
+		for i := 1; i < len(rtts); i++ {
+			j := rtts[i] - rtts[i-1]
+			if j < 0 {
+				j = -j
+			}
+			jsum += j
+			if j > mhop.JitterMax {
+				mhop.JitterMax = j
+			}
+			mhop.Jitter = j
+		}
+		mhop.JitterAvg = jsum / time.Duration(len(rtts)-1)
+	}
+
+	sorted := make([]time.Duration, len(rtts))
+	copy(sorted, rtts)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	if n := len(sorted); n%2 == 1 {
+		mhop.Median = sorted[n/2]
+	} else {
+		mhop.Median = (sorted[n/2-1] + sorted[n/2]) / 2
+	}
+	mhop.P90 = percentile(sorted, 90)
+	mhop.P99 = percentile(sorted, 99)
+}
+
+// percentile returns nearest-rank percentile p of sorted values
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
@@ -1,44 +1,94 @@
 package tracelib
 
 import (
-	"encoding/hex"
-	"fmt"
+	"bytes"
+	"errors"
 	"net"
-	"strconv"
-	"strings"
+	"sort"
 	"sync"
 	"time"
 )
 
-// MHop represents aggregated result of hop of multiply traces
+// MHop represents aggregated result of hop of multiply traces; RTT statistics
+// include Down replies, Lost counts failed probes answered by this address,
+// Loss is percentage of all probes of hop (TTL) without reply, because
+// probes lost on the way have no address to be counted for, and Jitter is last
+// difference between consecutive RTTs (JitterAvg/JitterMax like in mtr);
+// ReplyTTL, QuotedTTL and MPLS are taken from last reply containing them
 type MHop struct {
-	Addr   net.Addr
-	Host   string
-	AS     int64
-	MinRTT time.Duration
-	MaxRTT time.Duration
-	AvgRTT time.Duration
-	Total  int
-	Lost   int
-	Down   int
-	Final  bool
-}
-
-// AggregateMulti process result of RunMultiTrace and create aggregated result
-func AggregateMulti(hops [][]Hop) [][]MHop {
+	Addr          net.Addr
+	Host          string
+	HostConfirmed bool
+	AS            int64
+	Location      *Location
+	LocationHint  *Location
+	Class         AddrClass
+	IXP           *IXPInfo
+	GeoCheck      *GeoCheck
+	HintCheck     *GeoCheck
+	MinRTT        time.Duration
+	MaxRTT        time.Duration
+	AvgRTT        time.Duration
+	StdDev        time.Duration
+	Median        time.Duration
+	P90           time.Duration
+	P99           time.Duration
+	LastRTT       time.Duration
+	Jitter        time.Duration
+	JitterAvg     time.Duration
+	JitterMax     time.Duration
+	Total         int
+	Lost          int
+	Down          int
+	Loss          float64
+	LossKind      LossKind
+	ReplyTTL      int
+	QuotedTTL     int
+	MPLS          []MPLSLabel
+	Tunnel        *Tunnel
+	Reverse       *ReverseHop
+	Final         bool
+}
+
+// AggregatedHop represents aggregated result of one hop (TTL) of multiply traces
+type AggregatedHop struct {
+	// Responders are sorted by number of replies, then by address
+	Responders []MHop
+	// Lost is number of probes without any reply
+	Lost  int
+	Total int
+}
+
+// Loss returns percentage of probes without any reply
+func (h AggregatedHop) Loss() float64 {
+	if 0 == h.Total {
+		return 0
+	}
+	return 100 * float64(h.Lost) / float64(h.Total)
+}
+
+// AggregateHops process result of RunMultiTrace and create aggregated result
+// with stable order of responders of every hop
+func AggregateHops(hops [][]Hop) []AggregatedHop {
 
-	result := make([][]MHop, 0, len(hops))
+	result := make([]AggregatedHop, 0, len(hops))
 
 	for _, hop := range hops {
 		thishop := map[string]MHop{}
 
-		timesum := map[string]time.Duration{}
+		// RTTs of replies (including Down) in order of rounds
+		rtts := map[string][]time.Duration{}
+
+		var ahop AggregatedHop
 
 		for _, h := range hop {
-			var addrstring string
-			if nil != h.Addr {
-				addrstring = h.Addr.String()
+			ahop.Total++
+
+			if nil == h.Addr {
+				ahop.Lost++
+				continue
 			}
+			addrstring := h.Addr.String()
 
 			var (
 				mhop MHop
@@ -48,9 +98,13 @@ func AggregateMulti(hops [][]Hop) [][]MHop {
 			if mhop, ok = thishop[addrstring]; !ok {
 				mhop.Addr = h.Addr
 				mhop.Host = h.Host
+				mhop.HostConfirmed = h.HostConfirmed
 				mhop.AS = h.AS
+				mhop.Location = h.Location
+				mhop.LocationHint = h.LocationHint
+				mhop.Class = h.Class
+				mhop.IXP = h.IXP
 				mhop.Final = h.Final
-				timesum[addrstring] = 0
 			}
 
 			mhop.Total++
@@ -58,147 +112,247 @@ func AggregateMulti(hops [][]Hop) [][]MHop {
 			switch {
 			case h.Down:
 				mhop.Down++
+				rtts[addrstring] = append(rtts[addrstring], h.RTT)
 			case h.Timeout || nil != h.Error:
 				mhop.Lost++
+				ahop.Lost++
 			default:
-				timesum[addrstring] += h.RTT
-				if mhop.MaxRTT < h.RTT {
-					mhop.MaxRTT = h.RTT
-				}
-				if mhop.MinRTT == 0 || mhop.MinRTT > h.RTT {
-					mhop.MinRTT = h.RTT
-				}
+				rtts[addrstring] = append(rtts[addrstring], h.RTT)
 			}
 
-			mhop.Final = mhop.Final || h.Final
-			if mhop.Total > mhop.Lost {
-				mhop.AvgRTT = timesum[addrstring] / time.Duration(mhop.Total-mhop.Lost)
+			if h.ReplyTTL > 0 {
+				mhop.ReplyTTL = h.ReplyTTL
+			}
+			if h.QuotedTTL > 0 {
+				mhop.QuotedTTL = h.QuotedTTL
+			}
+			if len(h.MPLS) > 0 {
+				mhop.MPLS = h.MPLS
 			}
+
+			mhop.Final = mhop.Final || h.Final
 			thishop[addrstring] = mhop
 		}
 
-		thisSlice := make([]MHop, 0, len(thishop))
-		for _, h := range thishop {
-			thisSlice = append(thisSlice, h)
+		ahop.Responders = make([]MHop, 0, len(thishop))
+		for addrstring, h := range thishop {
+			h.setStats(rtts[addrstring])
+			h.Loss = ahop.Loss()
+			ahop.Responders = append(ahop.Responders, h)
 		}
+		sortMHops(ahop.Responders)
+
+		result = append(result, ahop)
+	}
+
+	return result
+}
 
-		result = append(result, thisSlice)
+// AggregateMulti process result of RunMultiTrace and create aggregated result;
+// it's AggregateHops without separate counters, probes without reply are
+// only reflected by Loss of responders (hop with no reply at all is empty)
+func AggregateMulti(hops [][]Hop) [][]MHop {
+
+	aggregated := AggregateHops(hops)
+	result := make([][]MHop, 0, len(aggregated))
+
+	for _, ahop := range aggregated {
+		result = append(result, ahop.Responders)
 	}
 
 	return result
 
 }
 
+// sortMHops sorts responders by number of replies, then by address
+func sortMHops(hops []MHop) {
+	sort.Slice(hops, func(i, j int) bool {
+		ri := hops[i].Total - hops[i].Lost
+		rj := hops[j].Total - hops[j].Lost
+		if ri != rj {
+			return ri > rj
+		}
+
+		ai := addrIP(hops[i].Addr).To16()
+		aj := addrIP(hops[j].Addr).To16()
+		if c := bytes.Compare(ai, aj); 0 != c {
+			return c < 0
+		}
+
+		return hops[i].Addr.String() < hops[j].Addr.String()
+	})
+}
+
 // LookupCache used to prevent AS-DNS requests for same hosts
 type LookupCache struct {
-	as     map[string]int64
+	as     map[string]asEntry
 	aMutex sync.RWMutex
-	hosts  map[string]string
+	hosts  map[string]hostEntry
 	hMutex sync.RWMutex
+	flight map[string]*lookupCall
+	fMutex sync.Mutex
+
+	// TTL sets how long cached entries stay valid, 0 means forever
+	TTL time.Duration
+	// ASResolver is backend used for AS lookups, CymruDNS if nil
+	ASResolver ASResolver
+	// ConfirmHosts enables forward-confirmation of reverse DNS names
+	ConfirmHosts bool
+	// Classifier used by EnrichHops, built-in ranges only if nil
+	Classifier *Classifier
+	// LookupPrivateHosts enables reverse DNS of non-routable addresses
+	// (e.g. with internal DNS), AS numbers are never looked up for them
+	LookupPrivateHosts bool
+}
+
+// asEntry is cached AS number with time of lookup
+type asEntry struct {
+	AS   int64     `json:"as"`
+	Time time.Time `json:"time"`
+}
+
+// hostEntry is cached hostname with time of lookup
+type hostEntry struct {
+	Host      string    `json:"host"`
+	Names     []string  `json:"names,omitempty"`
+	Confirmed bool      `json:"confirmed,omitempty"`
+	Checked   bool      `json:"checked,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// lookupCall is in-flight lookup other callers for same key are waiting for
+type lookupCall struct {
+	wg     sync.WaitGroup
+	result interface{}
 }
 
 // NewLookupCache constructor for LookupCache
 func NewLookupCache() *LookupCache {
 	return &LookupCache{
-		as:    make(map[string]int64, 1024),
-		hosts: make(map[string]string, 4096),
+		as:     make(map[string]asEntry, 1024),
+		hosts:  make(map[string]hostEntry, 4096),
+		flight: make(map[string]*lookupCall),
 	}
 }
 
-// LookupAS returns AS number for IP using origin.asn.cymru.com service
-func (cache *LookupCache) LookupAS(ip string) int64 {
-	cache.aMutex.RLock()
-	v, exist := cache.as[ip]
-	cache.aMutex.RUnlock()
-	if exist {
-		return v
+// do executes fn only once for all concurrent callers with same key
+func (cache *LookupCache) do(key string, fn func() interface{}) interface{} {
+	cache.fMutex.Lock()
+	if c, ok := cache.flight[key]; ok {
+		cache.fMutex.Unlock()
+		c.wg.Wait()
+		return c.result
 	}
+	c := &lookupCall{}
+	c.wg.Add(1)
+	cache.flight[key] = c
+	cache.fMutex.Unlock()
 
-	ipParts := strings.Split(ip, ".")
-	if len(ipParts) == 4 {
-		return cache.lookupAS4(ip, ipParts)
-	}
+	c.result = fn()
+	c.wg.Done()
 
-	return cache.lookupAS6(ip)
+	cache.fMutex.Lock()
+	delete(cache.flight, key)
+	cache.fMutex.Unlock()
+
+	return c.result
 }
 
-func (cache *LookupCache) lookupAS4(ip string, ipParts []string) int64 {
+// expired checks if entry looked up at t is too old to be used
+func (cache *LookupCache) expired(t time.Time) bool {
+	return cache.TTL > 0 && time.Since(t) > cache.TTL
+}
 
-	txts, err := net.LookupTXT(fmt.Sprintf("%s.%s.%s.%s.origin.asn.cymru.com", ipParts[3], ipParts[2], ipParts[1], ipParts[0]))
-	if nil != err || nil == txts || len(txts) < 1 {
-		return -1
+// resolver returns configured AS backend or Cymru DNS by default
+func (cache *LookupCache) resolver() ASResolver {
+	if nil != cache.ASResolver {
+		return cache.ASResolver
 	}
+	return CymruDNS{}
+}
+
+// storeAS saves resolved AS number, -1 for addresses without origin AS
+func (cache *LookupCache) storeAS(ip string, asnum int64) {
+	cache.aMutex.Lock()
+	cache.as[ip] = asEntry{AS: asnum, Time: time.Now()}
+	cache.aMutex.Unlock()
+}
 
-	parts := strings.Split(txts[0], " | ")
-	if len(parts) < 2 {
+// LookupAS returns AS number for IP using configured ASResolver
+// (origin.asn.cymru.com service by default), -1 if unknown
+func (cache *LookupCache) LookupAS(ip string) int64 {
+	if !ClassifyAddr(net.ParseIP(ip)).Routable() {
 		return -1
 	}
 
-	asnum, err := strconv.ParseInt(parts[0], 10, 64)
-	if nil != err {
-		return -1
+	cache.aMutex.RLock()
+	v, exist := cache.as[ip]
+	cache.aMutex.RUnlock()
+	if exist && !cache.expired(v.Time) {
+		return v.AS
 	}
 
-	cache.aMutex.Lock()
-	cache.as[ip] = asnum
-	cache.aMutex.Unlock()
+	return cache.do("as:"+ip, func() interface{} {
+		asnum, err := cache.resolver().LookupAS(ip)
+		if errors.Is(err, ErrASNotFound) {
+			// known to be unannounced, don't ask again until expired
+			cache.storeAS(ip, -1)
+			return int64(-1)
+		}
+		if nil != err {
+			return int64(-1)
+		}
 
-	return asnum
+		cache.storeAS(ip, asnum)
+		return asnum
+	}).(int64)
 }
 
-func (cache *LookupCache) lookupAS6(ip string) int64 {
-
-	i6 := net.ParseIP(ip)
-	if len(i6) != 16 {
-		return -1
+// PrefetchAS resolves all not yet cached addresses at once if configured
+// ASResolver supports bulk requests, otherwise it does nothing
+func (cache *LookupCache) PrefetchAS(ips []string) error {
+	bulk, ok := cache.resolver().(BulkASResolver)
+	if !ok {
+		return nil
 	}
 
-	hexIP := ""
-	for _, v := range hex.EncodeToString([]byte(i6)) {
-		hexIP = string(v) + "." + hexIP
+	missing := make([]string, 0, len(ips))
+	cache.aMutex.RLock()
+	for _, ip := range ips {
+		if !ClassifyAddr(net.ParseIP(ip)).Routable() {
+			continue
+		}
+		if v, exist := cache.as[ip]; !exist || cache.expired(v.Time) {
+			missing = append(missing, ip)
+		}
 	}
+	cache.aMutex.RUnlock()
 
-	txts, err := net.LookupTXT(hexIP + "origin6.asn.cymru.com")
-	if nil != err || nil == txts || len(txts) < 1 {
-		return -1
+	if len(missing) == 0 {
+		return nil
 	}
 
-	parts := strings.Split(txts[0], " | ")
-	if len(parts) < 2 {
-		return -1
+	// partial result is still worth storing on error
+	result, err := bulk.LookupASBulk(missing)
+	for ip, asnum := range result {
+		cache.storeAS(ip, asnum)
 	}
-
-	asnum, err := strconv.ParseInt(parts[0], 10, 64)
 	if nil != err {
-		return -1
+		return err
 	}
 
-	cache.aMutex.Lock()
-	cache.as[ip] = asnum
-	cache.aMutex.Unlock()
+	// addresses omitted from complete result have no origin AS, caching
+	// them avoids one by one lookups by LookupAS
+	for _, ip := range missing {
+		if _, ok := result[ip]; !ok {
+			cache.storeAS(ip, -1)
+		}
+	}
 
-	return asnum
+	return nil
 }
 
-// LookupHost returns AS number for IP using origin.asn.cymru.com service
+// LookupHost returns hostname for IP using reverse DNS lookup
 func (cache *LookupCache) LookupHost(ip string) string {
-	cache.hMutex.RLock()
-	v, exist := cache.hosts[ip]
-	cache.hMutex.RUnlock()
-	if exist {
-		return v
-	}
-
-	var result string
-
-	addrs, _ := net.LookupAddr(ip)
-	if len(addrs) > 0 {
-		result = addrs[0]
-	}
-
-	cache.hMutex.Lock()
-	cache.hosts[ip] = result
-	cache.hMutex.Unlock()
-
-	return result
+	return cache.LookupHostInfo(ip).Host
 }
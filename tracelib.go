@@ -1,9 +1,6 @@
 package tracelib
 
 import (
-	"encoding/binary"
-	"errors"
-	"math/rand"
 	"net"
 	"time"
 
@@ -40,40 +37,45 @@ type Callback func(info Hop, hopnum int, round int)
 
 // RunTrace preforms traceroute to specified host
 func RunTrace(host string, source string, source6 string, maxrtt time.Duration, maxttl int, DNScache *LookupCache, cb Callback) ([]Hop, error) {
-	hops := make([]Hop, 0, maxttl)
-
-	var res trace
-	var err error
-	isIPv6 := false
+	hops, _, _, err := runTrace(host, source, source6, maxrtt, maxttl, DNScache, cb, nil)
+	return hops, err
+}
 
-	addrList, err := net.LookupIP(host)
+// RunTraceOpts preforms traceroute to specified host with optional settings
+// and returns result with analysis attached
+func RunTraceOpts(host string, source string, source6 string, maxrtt time.Duration, maxttl int, DNScache *LookupCache, cb Callback, opts *Options) (*TraceResult, error) {
+	start := time.Now()
+	hops, dest, loop, err := runTrace(host, source, source6, maxrtt, maxttl, DNScache, cb, opts)
 	if nil != err {
 		return nil, err
 	}
 
-	for _, addr := range addrList {
-		if addr.To4() != nil {
-			res.dest, err = net.ResolveIPAddr("ip4:icmp", addr.String())
-			break
-		}
+	rounds := make([][]Hop, 0, len(hops))
+	for i := range hops {
+		rounds = append(rounds, hops[i:i+1])
 	}
+	result := newTraceResult(rounds, loop, start)
+	result.Dest = dest
 
-	if nil == res.dest {
-		for _, addr := range addrList {
-			if addr.To16() != nil {
-				isIPv6 = true
-				res.dest, err = net.ResolveIPAddr("ip6:58", addr.String())
-				break
-			}
-		}
-	}
-	if nil == res.dest {
-		return nil, errors.New("Unable to resolve destination host")
+	result.probeIPOption(source, maxrtt, opts)
+
+	return result, nil
+}
+
+// newTrace resolves host according to opts and opens raw socket(s) for
+// sequential trace
+func newTrace(host string, source string, source6 string, maxrtt time.Duration, maxttl int, opts *Options) (*trace, error) {
+	var res trace
+
+	dest, isIPv6, err := resolveDest(host, opts.family(), opts.resolver())
+	if nil != err {
+		return nil, err
 	}
+	res.dest = dest
 
 	res.maxrtt = maxrtt
 	res.maxttl = maxttl
-	res.id = rand.Int() % 0x7fff
+	res.id = acquireTraceID()
 	if isIPv6 {
 		res.msg = icmp.Message{Type: ipv6.ICMPTypeEchoRequest, Code: 0, Body: &icmp.Echo{ID: res.id, Seq: 1}}
 	} else {
@@ -82,6 +84,7 @@ func RunTrace(host string, source string, source6 string, maxrtt time.Duration,
 	res.netmsg, err = res.msg.Marshal(nil)
 
 	if nil != err {
+		releaseTraceID(res.id)
 		return nil, err
 	}
 
@@ -91,46 +94,81 @@ func RunTrace(host string, source string, source6 string, maxrtt time.Duration,
 		res.conn, err = net.ListenPacket("ip6:58", source6)
 	}
 	if nil != err {
+		releaseTraceID(res.id)
 		return nil, err
 	}
-	defer res.conn.Close()
 
 	if isIPv6 {
 		res.ipv6conn = ipv6.NewPacketConn(res.conn)
-		defer res.ipv6conn.Close()
 		if err := res.ipv6conn.SetControlMessage(ipv6.FlagHopLimit|ipv6.FlagSrc|ipv6.FlagDst|ipv6.FlagInterface, true); err != nil {
-			return nil, err
-		}
-		var f ipv6.ICMPFilter
-		f.SetAll(true)
-		f.Accept(ipv6.ICMPTypeTimeExceeded)
-		f.Accept(ipv6.ICMPTypeEchoReply)
-		f.Accept(ipv6.ICMPTypeDestinationUnreachable)
-		if err := res.ipv6conn.SetICMPFilter(&f); err != nil {
+			res.Close()
 			return nil, err
 		}
 	} else {
 		res.ipv4conn = ipv4.NewPacketConn(res.conn)
-		defer res.ipv4conn.Close()
+		if err := res.ipv4conn.SetControlMessage(ipv4.FlagTTL, true); err != nil {
+			res.Close()
+			return nil, err
+		}
+	}
+
+	return &res, nil
+}
+
+// filterICMP limits ICMPv6 socket to messages trace is interested in,
+// it does nothing for IPv4
+func (t *trace) filterICMP() error {
+	if nil == t.ipv6conn {
+		return nil
+	}
+
+	var f ipv6.ICMPFilter
+	f.SetAll(true)
+	f.Accept(ipv6.ICMPTypeTimeExceeded)
+	f.Accept(ipv6.ICMPTypeEchoReply)
+	f.Accept(ipv6.ICMPTypeDestinationUnreachable)
+	return t.ipv6conn.SetICMPFilter(&f)
+}
+
+// Close closes all sockets of trace
+func (t *trace) Close() {
+	if nil != t.ipv4conn {
+		t.ipv4conn.Close()
+	}
+	if nil != t.ipv6conn {
+		t.ipv6conn.Close()
+	}
+	t.conn.Close()
+	releaseTraceID(t.id)
+}
+
+func runTrace(host string, source string, source6 string, maxrtt time.Duration, maxttl int, DNScache *LookupCache, cb Callback, opts *Options) ([]Hop, net.Addr, *Loop, error) {
+	hops := make([]Hop, 0, maxttl)
+
+	res, err := newTrace(host, source, source6, maxrtt, maxttl, opts)
+	if nil != err {
+		return nil, nil, nil, err
+	}
+	defer res.Close()
+
+	if err := res.filterICMP(); nil != err {
+		return nil, nil, nil, err
 	}
 
 	timeouts := 0
 	for i := 1; i <= maxttl; i++ {
 		next := res.Step(i)
-		if nil != next.Addr {
-			addrString := next.Addr.String()
-			if nil != DNScache {
-				next.Host = DNScache.LookupHost(addrString)
-				next.AS = DNScache.LookupAS(addrString)
-			}
-		}
 		if nil != cb {
+			DNScache.enrichHop(&next)
 			cb(next, i, 1)
 		}
 		hops = append(hops, next)
 		if next.Final {
 			break
 		}
+		if opts.stopOnLoop() && nil != DetectLoop(hops, opts.loopRepeats()) {
+			break
+		}
 		if next.Timeout {
 			timeouts++
 		} else {
@@ -141,83 +179,41 @@ func RunTrace(host string, source string, source6 string, maxrtt time.Duration,
 		}
 	}
 
-	return hops, nil
+	enrichTrace([][]Hop{hops}, DNScache)
+
+	return hops, res.dest, DetectLoop(hops, opts.loopRepeats()), nil
 }
 
 // RunMultiTrace preforms traceroute to specified host testing each hop several times
 func RunMultiTrace(host string, source string, source6 string, maxrtt time.Duration, maxttl int, DNScache *LookupCache, rounds int, cb Callback) ([][]Hop, error) {
-	hops := make([][]Hop, 0, maxttl)
-
-	var res trace
-	var err error
-	isIPv6 := false
+	hops, _, _, err := runMultiTrace(host, source, source6, maxrtt, maxttl, DNScache, rounds, cb, nil)
+	return hops, err
+}
 
-	addrList, err := net.LookupIP(host)
+// RunMultiTraceOpts preforms traceroute to specified host testing each hop
+// several times with optional settings and returns result with analysis attached
+func RunMultiTraceOpts(host string, source string, source6 string, maxrtt time.Duration, maxttl int, DNScache *LookupCache, rounds int, cb Callback, opts *Options) (*TraceResult, error) {
+	start := time.Now()
+	hops, dest, loop, err := runMultiTrace(host, source, source6, maxrtt, maxttl, DNScache, rounds, cb, opts)
 	if nil != err {
 		return nil, err
 	}
 
-	for _, addr := range addrList {
-		if addr.To4() != nil {
-			res.dest, err = net.ResolveIPAddr("ip4:icmp", addr.String())
-			break
-		}
-	}
-
-	if nil == res.dest {
-		for _, addr := range addrList {
-			if addr.To16() != nil {
-				isIPv6 = true
-				res.dest, err = net.ResolveIPAddr("ip6:58", addr.String())
-				break
-			}
-		}
-	}
-	if nil == res.dest {
-		return nil, errors.New("Unable to resolve destination host")
-	}
+	result := newTraceResult(hops, loop, start)
+	result.Dest = dest
+	result.probeIPOption(source, maxrtt, opts)
 
-	res.maxrtt = maxrtt
-	res.maxttl = maxttl
-	res.id = rand.Int() % 0x7fff
-	if isIPv6 {
-		res.msg = icmp.Message{Type: ipv6.ICMPTypeEchoRequest, Code: 0, Body: &icmp.Echo{ID: res.id, Seq: 1}}
-	} else {
-		res.msg = icmp.Message{Type: ipv4.ICMPTypeEcho, Code: 0, Body: &icmp.Echo{ID: res.id, Seq: 1}}
-	}
-	res.netmsg, err = res.msg.Marshal(nil)
+	return result, nil
+}
 
-	if nil != err {
-		return nil, err
-	}
+func runMultiTrace(host string, source string, source6 string, maxrtt time.Duration, maxttl int, DNScache *LookupCache, rounds int, cb Callback, opts *Options) ([][]Hop, net.Addr, *Loop, error) {
+	hops := make([][]Hop, 0, maxttl)
 
-	if !isIPv6 {
-		res.conn, err = net.ListenPacket("ip4:icmp", source)
-	} else {
-		res.conn, err = net.ListenPacket("ip6:58", source6)
-	}
+	res, err := newTrace(host, source, source6, maxrtt, maxttl, opts)
 	if nil != err {
-		return nil, err
-	}
-	defer res.conn.Close()
-
-	if isIPv6 {
-		res.ipv6conn = ipv6.NewPacketConn(res.conn)
-		defer res.ipv6conn.Close()
-		if err := res.ipv6conn.SetControlMessage(ipv6.FlagHopLimit|ipv6.FlagSrc|ipv6.FlagDst|ipv6.FlagInterface, true); err != nil {
-			return nil, err
-		}
-		// var f ipv6.ICMPFilter
-		// f.SetAll(true)
-		// f.Accept(ipv6.ICMPTypeTimeExceeded)
-		// f.Accept(ipv6.ICMPTypeEchoReply)
-		// if err := res.ipv6conn.SetICMPFilter(&f); err != nil {
-		// 	return nil, err
-		// }
-	} else {
-		res.ipv4conn = ipv4.NewPacketConn(res.conn)
-		defer res.ipv4conn.Close()
+		return nil, nil, nil, err
 	}
+	defer res.Close()
 
 	timeouts := 0
 	for i := 1; i <= maxttl; i++ {
@@ -227,14 +223,8 @@ func RunMultiTrace(host string, source string, source6 string, maxrtt time.Durat
 		notimeout := true
 		for j := 0; j < rounds; j++ {
 			next := res.Step(i)
-			if nil != next.Addr {
-				addrString := next.Addr.String()
-				if nil != DNScache {
-					next.Host = DNScache.LookupHost(addrString)
-					next.AS = DNScache.LookupAS(addrString)
-				}
-			}
 			if nil != cb {
+				DNScache.enrichHop(&next)
 				cb(next, i, j+1)
 			}
 			thisHops = append(thisHops, next)
@@ -245,6 +235,9 @@ func RunMultiTrace(host string, source string, source6 string, maxrtt time.Durat
 		if isFinal {
 			break
 		}
+		if opts.stopOnLoop() && nil != DetectMultiLoop(hops, opts.loopRepeats()) {
+			break
+		}
 		if notimeout {
 			timeouts = 0
 		} else {
@@ -256,19 +249,31 @@ func RunMultiTrace(host string, source string, source6 string, maxrtt time.Durat
 		}
 	}
 
-	return hops, nil
+	enrichTrace(hops, DNScache)
+
+	return hops, res.dest, DetectMultiLoop(hops, opts.loopRepeats()), nil
 }
 
-// Hop represents each hop of trace
+// Hop represents each hop of trace; ReplyTTL is TTL (hop limit) of reply,
+// 0 if unknown, QuotedTTL is TTL of probe quoted in time exceeded message
+// and MPLS is quoted label stack (RFC 4950)
 type Hop struct {
-	Addr    net.Addr
-	Host    string
-	AS      int64
-	RTT     time.Duration
-	Final   bool
-	Timeout bool
-	Down    bool
-	Error   error
+	Addr          net.Addr
+	Host          string
+	HostConfirmed bool
+	AS            int64
+	Location      *Location
+	LocationHint  *Location
+	Class         AddrClass
+	IXP           *IXPInfo
+	RTT           time.Duration
+	ReplyTTL      int
+	QuotedTTL     int
+	MPLS          []MPLSLabel
+	Final         bool
+	Timeout       bool
+	Down          bool
+	Error         error
 }
 
 // Step sends one echo packet and waits for result
@@ -309,7 +314,20 @@ func (t *trace) Step(ttl int) Hop {
 	for {
 		var readLen int
 
-		readLen, hop.Addr, hop.Error = t.conn.ReadFrom(buf)
+		if nil != t.ipv4conn {
+			var cm *ipv4.ControlMessage
+			readLen, cm, hop.Addr, hop.Error = t.ipv4conn.ReadFrom(buf)
+			if nil != cm {
+				hop.ReplyTTL = cm.TTL
+			}
+		}
+		if nil != t.ipv6conn {
+			var cm *ipv6.ControlMessage
+			readLen, cm, hop.Addr, hop.Error = t.ipv6conn.ReadFrom(buf)
+			if nil != cm {
+				hop.ReplyTTL = cm.HopLimit
+			}
+		}
 
 		if nerr, ok := hop.Error.(net.Error); ok && nerr.Timeout() {
 			hop.Timeout = true
@@ -334,7 +352,7 @@ func (t *trace) Step(ttl int) Hop {
 		hop.RTT = time.Since(sendOn)
 
 		switch result.Type {
-		case ipv4.ICMPTypeEchoReply:
+		case ipv4.ICMPTypeEchoReply, ipv6.ICMPTypeEchoReply:
 			if rply, ok := result.Body.(*icmp.Echo); ok {
 				if t.id != rply.ID {
 					continue
@@ -342,48 +360,15 @@ func (t *trace) Step(ttl int) Hop {
 				hop.Final = true
 				return hop
 			}
-		case ipv4.ICMPTypeTimeExceeded:
-			if rply, ok := result.Body.(*icmp.TimeExceeded); ok {
-				if len(rply.Data) > 24 {
-					if uint16(t.id) != binary.BigEndian.Uint16(rply.Data[24:26]) {
-						continue
-					}
-					return hop
-				}
-			}
-		case ipv6.ICMPTypeTimeExceeded:
-			if rply, ok := result.Body.(*icmp.TimeExceeded); ok {
-				if len(rply.Data) > 44 {
-					if uint16(t.id) != binary.BigEndian.Uint16(rply.Data[44:46]) {
-						continue
-					}
-					return hop
-				}
-			}
-		case ipv6.ICMPTypeEchoReply:
-			if rply, ok := result.Body.(*icmp.Echo); ok {
-				if t.id != rply.ID {
-					continue
-				}
-				hop.Final = true
-				return hop
-			}
-		case ipv6.ICMPTypeDestinationUnreachable:
-			if rply, ok := result.Body.(*icmp.Echo); ok {
-				if t.id != rply.ID {
-					continue
-				}
-				hop.Down = true
-				return hop
-			}
-		case ipv4.ICMPTypeDestinationUnreachable:
-			if rply, ok := result.Body.(*icmp.Echo); ok {
-				if t.id != rply.ID {
-					continue
-				}
-				hop.Down = true
-				return hop
+		case ipv4.ICMPTypeTimeExceeded, ipv6.ICMPTypeTimeExceeded,
+			ipv4.ICMPTypeDestinationUnreachable, ipv6.ICMPTypeDestinationUnreachable:
+			q, ok := quotedFrom(result.Body)
+			if !ok || !q.isEcho() || t.id != q.id {
+				continue
 			}
+			hop.Down = ipv4.ICMPTypeDestinationUnreachable == result.Type || ipv6.ICMPTypeDestinationUnreachable == result.Type
+			setQuoted(&hop, &q)
+			return hop
 		}
 	}
 }